@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asnInfo is what the RIR delegated files and the AS names file tell about
+// an AS number.
+type asnInfo struct {
+	registry    string
+	countryCode string
+	name        string
+}
+
+// asnEnrichment collects the --rir and --as-names data used to add the
+// organization, registry and country to the AS numbers of records.
+type asnEnrichment struct {
+	asns map[uint32]*asnInfo
+}
+
+func loadASNEnrichment(rirFiles []string, asNamesFile string) (*asnEnrichment, error) {
+	e := &asnEnrichment{asns: map[uint32]*asnInfo{}}
+	for _, file := range rirFiles {
+		delegated, err := readDelegatedFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(delegated.errors) > 0 {
+			return nil, fmt.Errorf("RIR file %s line %d: %s", file, delegated.errors[0].line, delegated.errors[0].message)
+		}
+		for _, row := range delegated.rows {
+			if row.resource != "asn" {
+				continue
+			}
+			for i := uint64(0); i < row.count; i++ {
+				info := e.info(uint32(row.start.Uint64() + i))
+				info.registry = row.registry
+				info.countryCode = row.countryCode
+			}
+		}
+	}
+
+	if asNamesFile != "" {
+		if err := e.loadASNames(asNamesFile); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *asnEnrichment) info(asn uint32) *asnInfo {
+	info, ok := e.asns[asn]
+	if !ok {
+		info = &asnInfo{}
+		e.asns[asn] = info
+	}
+	return info
+}
+
+// loadASNames reads "<asn> <organization>" lines, the number may have an AS
+// prefix, e.g. "AS13335 CLOUDFLARENET - Cloudflare, Inc., US".
+func (e *asnEnrichment) loadASNames(path string) error {
+	f, err := openInput(path)
+	if err != nil {
+		return fmt.Errorf("opening AS names file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		number, name, _ := strings.Cut(text, " ")
+		if i := strings.IndexByte(number, '\t'); i >= 0 {
+			number, name = number[:i], number[i+1:]+" "+name
+		}
+		asn, err := parseASN(number)
+		if err != nil {
+			return fmt.Errorf("AS names file line %d: %w", line, err)
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			e.info(asn).name = name
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading AS names file: %w", err)
+	}
+	return nil
+}
+
+// recordData builds a GeoLite2-ASN shaped record for an AS number, with the
+// registry and country_code of the AS when the RIR files have them.
+func (e *asnEnrichment) recordData(asn uint32) map[string]any {
+	data := map[string]any{
+		"autonomous_system_number": typedNumber("uint32", strconv.FormatUint(uint64(asn), 10)),
+	}
+	if info, ok := e.asns[asn]; ok {
+		if info.name != "" {
+			data["autonomous_system_organization"] = info.name
+		}
+		if info.registry != "" {
+			data["registry"] = info.registry
+		}
+		if info.countryCode != "" {
+			data["country_code"] = info.countryCode
+		}
+	}
+	return data
+}
+
+func parseASN(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "AS"), "as")
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number %q", s)
+	}
+	return uint32(asn), nil
+}
+
+// delegatedFile is a parsed RIR delegated-*-extended statistics file
+type delegatedFile struct {
+	rows   []delegatedRow
+	errors []delegatedError
+}
+
+// delegatedError is a row that could not be parsed
+type delegatedError struct {
+	line    int
+	message string
+}
+
+// delegatedRow is a registry|cc|type|start|value|date|status[|opaque-id]
+// row for an ipv4, ipv6 or asn resource.
+type delegatedRow struct {
+	line        int
+	registry    string
+	countryCode string
+	resource    string
+	start       *big.Int
+	// count is the number of addresses or AS numbers, for ipv6 the value
+	// is the prefix length
+	count    uint64
+	value    string
+	status   string
+	opaqueID string
+}
+
+func readDelegatedFile(path string) (*delegatedFile, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening RIR file: %w", err)
+	}
+	defer f.Close()
+
+	file := &delegatedFile{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "|")
+		// The version header and the summary lines are skipped
+		if len(fields) < 7 || fields[1] == "*" || fields[3] == "*" {
+			continue
+		}
+
+		row := delegatedRow{
+			line:        line,
+			registry:    fields[0],
+			countryCode: strings.ToUpper(fields[1]),
+			resource:    fields[2],
+			value:       fields[4],
+			status:      fields[6],
+		}
+		if len(fields) > 7 {
+			row.opaqueID = fields[7]
+		}
+
+		var parseErr error
+		switch row.resource {
+		case "asn":
+			var asn uint32
+			asn, parseErr = parseASN(fields[3])
+			row.start = big.NewInt(int64(asn))
+			if parseErr == nil {
+				row.count, parseErr = strconv.ParseUint(row.value, 10, 32)
+			}
+			if parseErr == nil && row.count > 0 && uint64(asn)+row.count-1 > math.MaxUint32 {
+				parseErr = fmt.Errorf("asn range %d+%d ends beyond %d", asn, row.count, uint32(math.MaxUint32))
+			}
+		case "ipv4", "ipv6":
+			var addr netip.Addr
+			addr, parseErr = netip.ParseAddr(fields[3])
+			row.start = new(big.Int).SetBytes(addr.AsSlice())
+			if parseErr == nil && addr.Is4() != (row.resource == "ipv4") {
+				parseErr = fmt.Errorf("%s is not an %s address", addr, row.resource)
+			}
+			if parseErr == nil {
+				row.count, parseErr = strconv.ParseUint(row.value, 10, 64)
+			}
+			if parseErr == nil && row.resource == "ipv6" && row.count > 128 {
+				parseErr = fmt.Errorf("invalid ipv6 prefix length %d", row.count)
+			}
+		default:
+			continue
+		}
+		if parseErr != nil {
+			file.errors = append(file.errors, delegatedError{line: line, message: parseErr.Error()})
+			continue
+		}
+		file.rows = append(file.rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading RIR file: %w", err)
+	}
+	return file, nil
+}
+
+// rirRecordReader turns the ipv4 and ipv6 rows of a delegated file into
+// records with the registry and country of the allocation. When the
+// opaque-id of an allocation holds exactly one AS number, the AS number
+// and its organization are added too. The file does not tell which AS of an
+// opaque-id with several AS numbers announces a range, so those ranges get
+// no AS number. Rows that could not be parsed are returned as errors in
+// file order.
+type rirRecordReader struct {
+	file       *delegatedFile
+	enrichment *asnEnrichment
+	orgASNs    map[string][]uint32
+	index      int
+	errorIndex int
+	line       int
+}
+
+func newRIRRecordReader(path string, enrichment *asnEnrichment) (*rirRecordReader, error) {
+	file, err := readDelegatedFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rirRecordReader{file: file, enrichment: enrichment, orgASNs: map[string][]uint32{}}
+	for _, row := range file.rows {
+		if row.resource == "asn" && row.opaqueID != "" && isDelegated(row.status) {
+			for i := uint64(0); i < row.count; i++ {
+				r.orgASNs[row.opaqueID] = append(r.orgASNs[row.opaqueID], uint32(row.start.Uint64()+i))
+			}
+		}
+	}
+	return r, nil
+}
+
+// isDelegated reports whether a resource is in use, available and
+// reserved resources have no holder
+func isDelegated(status string) bool {
+	return status == "allocated" || status == "assigned"
+}
+
+func (r *rirRecordReader) Metadata() Metadata {
+	return Metadata{
+		DatabaseType: "RIR-Delegations",
+		Description:  map[string]string{"en": "RIR delegations imported from delegated statistics"},
+	}
+}
+
+func (r *rirRecordReader) Next() (JSONRecord, error) {
+	for {
+		if r.errorIndex < len(r.file.errors) {
+			err := r.file.errors[r.errorIndex]
+			if r.index >= len(r.file.rows) || err.line < r.file.rows[r.index].line {
+				r.errorIndex++
+				r.line = err.line
+				return JSONRecord{}, r.rowError(err.message)
+			}
+		}
+		if r.index >= len(r.file.rows) {
+			return JSONRecord{}, io.EOF
+		}
+
+		row := r.file.rows[r.index]
+		r.index++
+		r.line = row.line
+		if row.resource == "asn" || !isDelegated(row.status) {
+			continue
+		}
+
+		record := JSONRecord{Data: map[string]any{"registry": row.registry}}
+		if row.countryCode != "" {
+			record.Data["country_code"] = row.countryCode
+		}
+		if asns := r.orgASNs[row.opaqueID]; row.opaqueID != "" && len(asns) == 1 {
+			for key, value := range r.enrichment.recordData(asns[0]) {
+				if _, ok := record.Data[key]; !ok {
+					record.Data[key] = value
+				}
+			}
+		}
+
+		if row.resource == "ipv6" {
+			start := netip.AddrFrom16([16]byte(row.start.FillBytes(make([]byte, 16))))
+			record.Network = fmt.Sprintf("%s/%s", start, row.value)
+			return record, nil
+		}
+
+		// ipv4 rows give the number of addresses, which need not be a
+		// power of two
+		start := netip.AddrFrom4([4]byte(row.start.FillBytes(make([]byte, 4))))
+		if row.count == 0 {
+			return JSONRecord{}, r.rowError("ipv4 count cannot be 0")
+		}
+		end := new(big.Int).Add(row.start, new(big.Int).SetUint64(row.count-1))
+		if end.Cmp(maxUint32) > 0 {
+			return JSONRecord{}, r.rowError(fmt.Sprintf("ipv4 range %s+%d ends beyond 255.255.255.255", start, row.count))
+		}
+		record.StartIP = start.String()
+		record.EndIP = netip.AddrFrom4([4]byte(end.FillBytes(make([]byte, 4)))).String()
+		return record, nil
+	}
+}
+
+func (r *rirRecordReader) rowError(message string) error {
+	return &ValidationError{
+		Field:   r.Location(),
+		Message: message,
+	}
+}
+
+func (r *rirRecordReader) Location() string {
+	return fmt.Sprintf("line %d", r.line)
+}
+
+func (r *rirRecordReader) Close() error {
+	return nil
+}
+
+// bgpRecordReader reads a routing table of "<prefix> <origin AS>" lines, or
+// the "TABLE_DUMP2|time|B|peer|peer AS|prefix|AS path|..." lines of
+// bgpdump -m, where the origin is the last AS of the path. A prefix seen
+// with several origins gets the one most peers announce. The whole table is
+// read on open to merge the views of all peers.
+type bgpRecordReader struct {
+	prefixes   []netip.Prefix
+	origins    map[netip.Prefix]uint32
+	errors     []*ValidationError
+	enrichment *asnEnrichment
+	index      int
+	location   string
+}
+
+func newBGPRecordReader(path string, enrichment *asnEnrichment) (*bgpRecordReader, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	r := &bgpRecordReader{enrichment: enrichment}
+	counts := map[netip.Prefix]map[uint32]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		prefix, origin, ok, err := parseBGPLine(text)
+		if err != nil {
+			r.errors = append(r.errors, &ValidationError{
+				Field:   fmt.Sprintf("line %d", line),
+				Message: err.Error(),
+			})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if counts[prefix] == nil {
+			counts[prefix] = map[uint32]int{}
+			r.prefixes = append(r.prefixes, prefix)
+		}
+		counts[prefix][origin]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+
+	r.origins = make(map[netip.Prefix]uint32, len(counts))
+	for prefix, origins := range counts {
+		best, bestCount := uint32(0), 0
+		for origin, count := range origins {
+			if count > bestCount || (count == bestCount && origin < best) {
+				best, bestCount = origin, count
+			}
+		}
+		r.origins[prefix] = best
+	}
+	return r, nil
+}
+
+// parseBGPLine returns the prefix and origin AS of a table line. ok is false
+// for lines without a single origin, like withdrawals and AS sets.
+func parseBGPLine(line string) (prefix netip.Prefix, origin uint32, ok bool, err error) {
+	var network, path string
+	if strings.Contains(line, "|") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 7 || fields[2] != "B" && fields[2] != "A" {
+			return prefix, 0, false, nil
+		}
+		network, path = fields[5], fields[6]
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return prefix, 0, false, fmt.Errorf("expected a prefix and an origin AS")
+		}
+		network, path = fields[0], fields[len(fields)-1]
+	}
+
+	prefix, err = netip.ParsePrefix(network)
+	if err != nil {
+		return prefix, 0, false, fmt.Errorf("invalid prefix: %v", err)
+	}
+	hops := strings.Fields(path)
+	if len(hops) == 0 || strings.HasPrefix(hops[len(hops)-1], "{") {
+		return prefix, 0, false, nil
+	}
+	origin, err = parseASN(hops[len(hops)-1])
+	if err != nil {
+		return prefix, 0, false, err
+	}
+	return prefix.Masked(), origin, true, nil
+}
+
+func (r *bgpRecordReader) Metadata() Metadata {
+	return Metadata{
+		DatabaseType: "ASN",
+		Description:  map[string]string{"en": "ASN database built from a BGP table"},
+	}
+}
+
+func (r *bgpRecordReader) Next() (JSONRecord, error) {
+	if len(r.errors) > 0 {
+		err := r.errors[0]
+		r.errors = r.errors[1:]
+		r.location = err.Field
+		return JSONRecord{}, err
+	}
+	if r.index == 0 {
+		// Less specific prefixes first, so more specific ones override them
+		sort.Slice(r.prefixes, func(i, j int) bool {
+			if r.prefixes[i].Bits() != r.prefixes[j].Bits() {
+				return r.prefixes[i].Bits() < r.prefixes[j].Bits()
+			}
+			return r.prefixes[i].Addr().Less(r.prefixes[j].Addr())
+		})
+	}
+	if r.index >= len(r.prefixes) {
+		return JSONRecord{}, io.EOF
+	}
+
+	prefix := r.prefixes[r.index]
+	r.index++
+	r.location = "prefix " + prefix.String()
+	return JSONRecord{
+		Network: prefix.String(),
+		Data:    r.enrichment.recordData(r.origins[prefix]),
+	}, nil
+}
+
+func (r *bgpRecordReader) Location() string {
+	return r.location
+}
+
+func (r *bgpRecordReader) Close() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDelegatedFileASNRange(t *testing.T) {
+	tests := []struct {
+		row     string
+		wantErr bool
+	}{
+		{"arin|US|asn|64512|10|20200101|allocated", false},
+		{"arin|US|asn|4294967295|1|20200101|allocated", false},
+		{"arin|US|asn|4294967290|6|20200101|allocated", false},
+		{"arin|US|asn|4294967290|7|20200101|allocated", true},
+		{"arin|US|asn|2|4294967295|20200101|allocated", true},
+		{"arin|US|asn|1|4294967296|20200101|allocated", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.row, func(t *testing.T) {
+			path := writeTestFile(t, "delegated.txt", "2|arin|20200101|1|19700101|20200101|+0000\n"+tt.row+"\n")
+			file, err := readDelegatedFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(file.errors) > 0; got != tt.wantErr {
+				t.Errorf("errors = %v, want an error %v", file.errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRIRRecordReader(t *testing.T) {
+	path := writeTestFile(t, "delegated.txt", `2|arin|20200101|6|19700101|20200101|+0000
+arin|*|ipv4|*|2|summary
+arin|US|ipv4|1.0.0.0|256|20200101|allocated|org-1
+arin|US|ipv4|1.0.1|256|20200101|allocated|org-1
+arin|US|asn|64512|1|20200101|allocated|org-1
+arin|DE|ipv6|2a00::|32|20200101|assigned|org-2
+arin|US|asn|AS-x|1|20200101|allocated|org-2
+arin|US|asn|64513|2|20200101|allocated|org-2
+arin|US|ipv4|2.0.0.0|256|20200101|available|
+`)
+	reader, err := newRIRRecordReader(path, &asnEnrichment{asns: map[uint32]*asnInfo{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *ValidationError
+		if errors.As(err, &recordErr) {
+			got = append(got, fmt.Sprintf("%s: error", reader.Location()))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		network := record.Network
+		if network == "" {
+			network = record.StartIP + "-" + record.EndIP
+		}
+		got = append(got, fmt.Sprintf("%s: %s %s asn %v", reader.Location(), network, record.Data["country_code"], record.Data["autonomous_system_number"]))
+	}
+
+	// org-2 has two AS numbers, so its range gets none
+	want := []string{
+		"line 3: 1.0.0.0-1.0.0.255 US asn map[$type:uint32 value:64512]",
+		"line 4: error",
+		"line 6: 2a00::/32 DE asn <nil>",
+		"line 7: error",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+)
+
+// InputSettings are the input related settings of a build as given on the
+// command line or in a build file, before the files they name are loaded.
+type InputSettings struct {
+	Format       string
+	MappingFile  string
+	Profile      string
+	SchemaFile   string
+	MetadataFile string
+	Metadata     *Metadata
+	ListData     map[string]any
+	RIRFiles     []string
+	ASNamesFile  string
+	PrimaryFile  string
+	BaseFile     string
+}
+
+// loadInputOptions loads the mapping, schema, profile and base database an
+// input refers to. It also returns the IP version of the base database.
+func loadInputOptions(s InputSettings) (InputOptions, int, error) {
+	opts := InputOptions{
+		Format:       s.Format,
+		MetadataFile: s.MetadataFile,
+		Metadata:     s.Metadata,
+		RIRFiles:     s.RIRFiles,
+		ASNamesFile:  s.ASNamesFile,
+		ListData:     s.ListData,
+		PrimaryFile:  s.PrimaryFile,
+	}
+	if s.SchemaFile != "" {
+		schema, err := loadSchema(s.SchemaFile)
+		if err != nil {
+			return opts, 0, fmt.Errorf("loading schema: %w", err)
+		}
+		opts.Schema = schema
+		opts.SchemaFile = s.SchemaFile
+	}
+	if s.MappingFile != "" {
+		mapping, err := loadCSVMapping(s.MappingFile)
+		if err != nil {
+			return opts, 0, fmt.Errorf("loading mapping: %w", err)
+		}
+		opts.Mapping = mapping
+		opts.MappingFile = s.MappingFile
+	}
+	if s.Profile != "" {
+		if s.MappingFile != "" {
+			return opts, 0, fmt.Errorf("--profile and --mapping cannot be used together")
+		}
+		mapping, err := profileMapping(s.Profile)
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.Format = "csv"
+		opts.Mapping = mapping
+	}
+
+	var baseIPVersion int
+	if s.BaseFile != "" {
+		baseMetadata, ipVersion, err := readBaseMetadata(s.BaseFile)
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.BaseMetadata = &baseMetadata
+		baseIPVersion = ipVersion
+	}
+	return opts, baseIPVersion, nil
+}
+
+// listDataFromMap turns the data of a list input given as a decoded YAML or
+// JSON object into the form parseListData returns, numbers as json.Number.
+func listDataFromMap(data map[string]any) (map[string]any, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseListData(string(encoded))
+}
+
+// WriterSettings are the mmdbwriter options that are not taken from the
+// metadata or detected from the input.
+type WriterSettings struct {
+	// RecordSize is 24, 28 or 32, 0 takes the record size of the metadata
+	// or the base database or uses 28.
+	RecordSize int `json:"record_size" yaml:"record_size"`
+	// IPVersion overrides the IP version of the metadata or the one
+	// detected from the records.
+	IPVersion               int  `json:"ip_version" yaml:"ip_version"`
+	IncludeReservedNetworks bool `json:"include_reserved_networks" yaml:"include_reserved_networks"`
+	DisableIPv4Aliasing     bool `json:"disable_ipv4_aliasing" yaml:"disable_ipv4_aliasing"`
+	DisableMetadataPointers bool `json:"disable_metadata_pointers" yaml:"disable_metadata_pointers"`
+}
+
+// BuildOptions describes one database build
+type BuildOptions struct {
+	Inputs        []string
+	Input         InputOptions
+	Merge         string
+	Numbers       NumberPolicy
+	BaseFile      string
+	BaseIPVersion int
+	Writer        WriterSettings
+	Output        string
+	// Reproducible builds require a fixed build epoch, so the same inputs
+	// give the same bytes
+	Reproducible bool
+	// Checksum and Manifest write OUTPUT.sha256 and OUTPUT.manifest.json
+	Checksum bool
+	Manifest bool
+	// SignKey signs the database into OUTPUT.sig
+	SignKey ed25519.PrivateKey
+	// Check is run on the written database before it replaces the output.
+	// When it fails the output and its sidecars are left as they were.
+	Check func(path string) error
+}
+
+// BuildResult summarizes a finished build
+type BuildResult struct {
+	Output    string
+	Records   int
+	IPVersion int
+	Metadata  Metadata
+	// SHA256 is set when the build was verified to be reproducible
+	SHA256 string
+}
+
+// buildDatabase validates the inputs, printing their summary, and writes
+// the database. Records that cannot be inserted are logged as warnings.
+func buildDatabase(opts BuildOptions) (BuildResult, error) {
+	started := time.Now()
+	// The policy applies to this build only, the next target of a build
+	// file starts from the command line flags again
+	defer func(policy NumberPolicy) { numberPolicy = policy }(numberPolicy)
+	numberPolicy = opts.Numbers
+	result := BuildResult{Output: opts.Output}
+
+	merge, err := lookupMergeStrategy(opts.Merge)
+	if err != nil {
+		return result, err
+	}
+	// The signature is made from the written file, which a device or pipe
+	// like /dev/stdout cannot give back
+	if opts.SignKey != nil {
+		if info, err := os.Stat(opts.Output); err == nil && !info.Mode().IsRegular() {
+			return result, fmt.Errorf("signing needs a regular output file, %s is not one", opts.Output)
+		}
+	}
+
+	// Validate input file before processing
+	summary, err := validateInputFile(opts.Inputs, opts.Input)
+	if err != nil {
+		return result, fmt.Errorf("invalid input file: %w", err)
+	}
+	metadata := summary.Metadata
+	result.Records = summary.Records
+
+	// Validate metadata
+	if err := validateMetadata(metadata); err != nil {
+		return result, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	// IP version was detected from the records during validation, or is
+	// the one of the base database. The metadata and then the writer
+	// settings override it.
+	ipVersion := summary.IPVersion
+	if opts.BaseFile != "" {
+		ipVersion = opts.BaseIPVersion
+	}
+	if metadata.IPVersion != nil {
+		ipVersion = *metadata.IPVersion
+	}
+	if opts.Writer.IPVersion != 0 {
+		ipVersion = opts.Writer.IPVersion
+	}
+	if ipVersion == 4 && summary.IPVersion == 6 && summary.Records > 0 {
+		if opts.BaseFile != "" && opts.BaseIPVersion == 4 {
+			return result, fmt.Errorf("the base database is IPv4 only, the input cannot add IPv6 networks unless ip_version is 6")
+		}
+		return result, fmt.Errorf("IP version 4 cannot hold the IPv6 networks of the input")
+	}
+	if ipVersion == 4 && opts.BaseIPVersion == 6 {
+		return result, fmt.Errorf("IP version 4 cannot hold the IPv6 networks of the base database")
+	}
+	log.Printf("%s: %d", infoColor("Detected IP version"), ipVersion)
+	result.IPVersion = ipVersion
+
+	recordSize := opts.Writer.RecordSize
+	if recordSize == 0 && metadata.RecordSize != nil {
+		recordSize = *metadata.RecordSize
+	}
+
+	// Set default metadata values
+	if metadata.Languages == nil || len(metadata.Languages) == 0 {
+		metadata.Languages = []string{"en"}
+	}
+	if metadata.BuildTimestamp == nil {
+		if opts.Reproducible && os.Getenv("SOURCE_DATE_EPOCH") == "" {
+			return result, fmt.Errorf("a reproducible build needs build_epoch in the metadata or SOURCE_DATE_EPOCH")
+		}
+		epoch, err := defaultBuildEpoch()
+		if err != nil {
+			return result, err
+		}
+		metadata.BuildTimestamp = &epoch
+	}
+	result.Metadata = metadata
+
+	// Create MMDB writer with metadata (note: BinaryVersion is always 2,
+	// other versions are rejected by validateMetadata)
+	writerOpts := mmdbwriter.Options{
+		DatabaseType:            metadata.DatabaseType,
+		Description:             metadata.Description,
+		Languages:               metadata.Languages,
+		IPVersion:               ipVersion,
+		RecordSize:              recordSize,
+		BuildEpoch:              *metadata.BuildTimestamp,
+		IncludeReservedNetworks: opts.Writer.IncludeReservedNetworks,
+		DisableIPv4Aliasing:     opts.Writer.DisableIPv4Aliasing,
+		DisableMetadataPointers: opts.Writer.DisableMetadataPointers,
+	}
+	var writer *mmdbwriter.Tree
+	if opts.BaseFile != "" {
+		// Unless a record size is given, the record size of the base
+		// database is kept
+		writer, err = mmdbwriter.Load(opts.BaseFile, writerOpts)
+		if err != nil {
+			return result, fmt.Errorf("loading base MMDB file: %w", err)
+		}
+	} else {
+		if writerOpts.RecordSize == 0 {
+			writerOpts.RecordSize = 28
+		}
+		writer, err = mmdbwriter.New(writerOpts)
+		if err != nil {
+			return result, fmt.Errorf("creating MMDB writer: %w", err)
+		}
+	}
+
+	// Stream records into the writer, the input is read a second time so
+	// memory use does not grow with the number of records
+	reader, err := openRecordReader(opts.Inputs, opts.Input)
+	if err != nil {
+		return result, fmt.Errorf("reading input file: %w", err)
+	}
+	defer reader.Close()
+
+	for i := 0; ; i++ {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("reading input file: %w", err)
+		}
+
+		// Coerce values to the types declared by the schema
+		if opts.Input.Schema != nil && record.Data != nil {
+			if record.Data, err = opts.Input.Schema.Coerce(record.Data); err != nil {
+				return result, fmt.Errorf("invalid record at %s: %w", reader.Location(), err)
+			}
+		}
+
+		if err := validateRecord(record); err != nil {
+			return result, fmt.Errorf("invalid record at %s: %w", reader.Location(), err)
+		}
+
+		if err := processRecord(writer, record, i, merge); err != nil {
+			log.Printf("Warning: Error processing record %s: %v", reader.Location(), err)
+		}
+	}
+
+	// Write the database to file, the sidecars are only written once the
+	// database is in place
+	write := func(w io.Writer) error {
+		_, err := writer.WriteTo(w)
+		return err
+	}
+	if err := writeFileAtomicChecked(opts.Output, write, opts.Check); err != nil {
+		return result, fmt.Errorf("writing database: %w", err)
+	}
+
+	log.Printf("%s: %s", successColor("Successfully created MMDB file"), opts.Output)
+
+	if opts.SignKey != nil {
+		if err := writeSignatureFile(opts.Output, opts.SignKey); err != nil {
+			return result, fmt.Errorf("writing signature: %w", err)
+		}
+		log.Printf("%s: %s.sig", successColor("Signed database"), opts.Output)
+	}
+
+	if opts.Checksum || opts.Manifest {
+		hash, err := fileSHA256(opts.Output)
+		if err != nil {
+			return result, err
+		}
+		if opts.Checksum {
+			if err := writeChecksumFile(opts.Output, hash); err != nil {
+				return result, fmt.Errorf("writing checksum: %w", err)
+			}
+			log.Printf("%s: %s.sha256", successColor("Wrote checksum"), opts.Output)
+		}
+		if opts.Manifest {
+			if err := writeManifest(opts, result, hash, started); err != nil {
+				return result, fmt.Errorf("writing manifest: %w", err)
+			}
+			log.Printf("%s: %s.manifest.json", successColor("Wrote manifest"), opts.Output)
+		}
+	}
+	return result, nil
+}
+
+// verifyReproducibleBuild builds the database, then builds it a second time
+// into a temporary file next to the output and compares the SHA-256 of both.
+// The SHA-256 is returned in the result.
+func verifyReproducibleBuild(opts BuildOptions) (BuildResult, error) {
+	opts.Reproducible = true
+	result, err := buildDatabase(opts)
+	if err != nil {
+		return result, err
+	}
+	hash, err := fileSHA256(opts.Output)
+	if err != nil {
+		return result, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(opts.Output), ".mmdbimport-verify-*")
+	if err != nil {
+		return result, fmt.Errorf("creating temporary file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	log.Printf("%s", infoColor("Building a second time to verify the build is reproducible"))
+	second := opts
+	second.Output = f.Name()
+	second.Checksum, second.Manifest, second.SignKey, second.Check = false, false, nil, nil
+	if _, err := buildDatabase(second); err != nil {
+		return result, err
+	}
+	secondHash, err := fileSHA256(second.Output)
+	if err != nil {
+		return result, err
+	}
+	if hash != secondHash {
+		return result, fmt.Errorf("build is not reproducible: sha256 %s and %s differ", hash, secondHash)
+	}
+
+	result.SHA256 = hash
+	log.Printf("%s: sha256 %s", successColor("Build is reproducible"), hash)
+	return result, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+func TestBuildDatabaseRestoresNumberPolicy(t *testing.T) {
+	defer func(policy NumberPolicy) { numberPolicy = policy }(numberPolicy)
+	numberPolicy = NumberPolicy{Integer: "auto", Float: "double"}
+
+	input := writeTestInput(t)
+	_, err := buildDatabase(BuildOptions{
+		Inputs:  []string{input},
+		Input:   InputOptions{Format: "json"},
+		Merge:   "replace",
+		Numbers: NumberPolicy{Integer: "uint64", Float: "float"},
+		Output:  filepath.Join(t.TempDir(), "test.mmdb"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numberPolicy != (NumberPolicy{Integer: "auto", Float: "double"}) {
+		t.Errorf("number policy = %+v after the build", numberPolicy)
+	}
+}
+
+func TestBuildDatabaseInvalidInput(t *testing.T) {
+	input := writeTestFile(t, "input.json", `{"metadata":{"database_type":"Test","description":{"en":"test"}},"records":[{"network":"invalid","data":{"a":1}}]}`)
+	_, err := buildDatabase(BuildOptions{
+		Inputs: []string{input},
+		Input:  InputOptions{Format: "json"},
+		Merge:  "replace",
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid input file: validation failed") {
+		t.Errorf("error = %v, want the validation error", err)
+	}
+}
+
+func TestBuildDatabaseBaseIPVersion(t *testing.T) {
+	dir := t.TempDir()
+	build := func(input, base string, output string) error {
+		opts, baseIPVersion, err := loadInputOptions(InputSettings{Format: "json", BaseFile: base})
+		if err != nil {
+			return err
+		}
+		_, err = buildDatabase(BuildOptions{
+			Inputs:        []string{writeTestFile(t, "input.json", input)},
+			Input:         opts,
+			Merge:         "replace",
+			Numbers:       numberPolicy,
+			BaseFile:      base,
+			BaseIPVersion: baseIPVersion,
+			Output:        output,
+		})
+		return err
+	}
+
+	base := filepath.Join(dir, "base.mmdb")
+	if err := build(`{"metadata":{"database_type":"Test","description":{"en":"test"},"build_epoch":1,"ip_version":4},"records":[{"network":"1.0.0.0/24","data":{"a":1}}]}`, "", base); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		ipVersion uint
+		wantErr   string
+	}{
+		{
+			name:      "base version kept",
+			input:     `{"metadata":{"build_epoch":1},"records":[{"network":"2.0.0.0/24","data":{"a":2}}]}`,
+			ipVersion: 4,
+		},
+		{
+			name:    "ipv6 networks need ip_version 6",
+			input:   `{"metadata":{"build_epoch":1},"records":[{"network":"2001:db8::/32","data":{"a":2}}]}`,
+			wantErr: "the base database is IPv4 only",
+		},
+		{
+			name:      "ip_version 6 upgrades the base",
+			input:     `{"metadata":{"build_epoch":1,"ip_version":6},"records":[{"network":"2001:db8::/32","data":{"a":2}}]}`,
+			ipVersion: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "patched.mmdb")
+			err := build(tt.input, base, output)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := maxminddb.Open(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if reader.Metadata.IPVersion != tt.ipVersion {
+				t.Errorf("ip_version = %d, want %d", reader.Metadata.IPVersion, tt.ipVersion)
+			}
+			var data map[string]any
+			if err := reader.Lookup(netip.MustParseAddr("1.0.0.1")).Decode(&data); err != nil || data == nil {
+				t.Errorf("base network lost: %v %v", data, err)
+			}
+		})
+	}
+
+	upgraded := filepath.Join(dir, "upgraded.mmdb")
+	if err := build(`{"metadata":{"build_epoch":1,"ip_version":6},"records":[]}`, base, upgraded); err != nil {
+		t.Fatal(err)
+	}
+	err := build(`{"metadata":{"build_epoch":1,"ip_version":4},"records":[]}`, upgraded, filepath.Join(dir, "downgraded.mmdb"))
+	if err == nil || !strings.Contains(err.Error(), "networks of the base database") {
+		t.Errorf("error = %v, want the base database error", err)
+	}
+}
+
+func TestVerifyReproducibleBuild(t *testing.T) {
+	input := writeTestFile(t, "input.json", `{"metadata":{"database_type":"Test","description":{"en":"test"}},"records":[{"network":"1.0.0.0/24","data":{"a":1}}]}`)
+	dir := t.TempDir()
+	opts := BuildOptions{
+		Inputs:  []string{input},
+		Input:   InputOptions{Format: "json"},
+		Merge:   "replace",
+		Numbers: numberPolicy,
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	var hashes []string
+	for _, name := range []string{"first.mmdb", "second.mmdb"} {
+		opts.Output = filepath.Join(dir, name)
+		result, err := verifyReproducibleBuild(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := fileSHA256(opts.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.SHA256 != hash {
+			t.Errorf("%s: result sha256 %s, file sha256 %s", name, result.SHA256, hash)
+		}
+		hashes = append(hashes, hash)
+	}
+	if hashes[0] != hashes[1] {
+		t.Errorf("builds differ: sha256 %s and %s", hashes[0], hashes[1])
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	opts.Output = filepath.Join(dir, "no-epoch.mmdb")
+	_, err := verifyReproducibleBuild(opts)
+	if err == nil || !strings.Contains(err.Error(), "needs build_epoch in the metadata or SOURCE_DATE_EPOCH") {
+		t.Errorf("error = %v, want the missing epoch error", err)
+	}
+	if _, err := os.Stat(opts.Output); !os.IsNotExist(err) {
+		t.Errorf("output written without a build epoch: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// BuildFile is a build file (JSON or YAML) declaring several databases that
+// are built in one run with `mmdbimport build -f mmdbbuild.yaml`. Paths are
+// relative to the directory of the build file.
+type BuildFile struct {
+	Targets []*BuildTarget `json:"targets" yaml:"targets"`
+}
+
+// BuildTarget declares one database, its fields match the command line flags
+type BuildTarget struct {
+	// Name identifies the target in the summary and for --target, it
+	// defaults to the file name of the output.
+	Name   string   `json:"name" yaml:"name"`
+	Inputs []string `json:"inputs" yaml:"inputs"`
+	Output string   `json:"output" yaml:"output"`
+
+	Format       string         `json:"format" yaml:"format"`
+	Mapping      string         `json:"mapping" yaml:"mapping"`
+	Profile      string         `json:"profile" yaml:"profile"`
+	Schema       string         `json:"schema" yaml:"schema"`
+	Data         map[string]any `json:"data" yaml:"data"`
+	RIR          []string       `json:"rir" yaml:"rir"`
+	ASNames      string         `json:"as_names" yaml:"as_names"`
+	Primary      string         `json:"primary" yaml:"primary"`
+	MetadataFile string         `json:"metadata_file" yaml:"metadata_file"`
+	// Metadata replaces the metadata of the inputs, like metadata_file
+	Metadata *Metadata `json:"metadata" yaml:"metadata"`
+	Base     string    `json:"base" yaml:"base"`
+
+	Merge     string         `json:"merge" yaml:"merge"`
+	IntType   string         `json:"int_type" yaml:"int_type"`
+	FloatType string         `json:"float_type" yaml:"float_type"`
+	Writer    WriterSettings `json:"writer" yaml:"writer"`
+
+	Reproducible       bool `json:"reproducible" yaml:"reproducible"`
+	VerifyReproducible bool `json:"verify_reproducible" yaml:"verify_reproducible"`
+	// Checksum and Manifest write sidecars next to the output
+	Checksum bool `json:"checksum" yaml:"checksum"`
+	Manifest bool `json:"manifest" yaml:"manifest"`
+	// SignKey is an ed25519 private key (PEM) signing the output
+	SignKey string `json:"sign_key" yaml:"sign_key"`
+
+	// Checks are looked up in the new database before it replaces the
+	// output
+	Checks []*BuildCheck `json:"checks" yaml:"checks"`
+}
+
+// BuildCheck looks up an address in a built database. Expect maps field
+// paths to the values they must have, Found false expects no record.
+type BuildCheck struct {
+	IP     string         `json:"ip" yaml:"ip"`
+	Found  *bool          `json:"found" yaml:"found"`
+	Expect map[string]any `json:"expect" yaml:"expect"`
+}
+
+// loadBuildFile reads a build file, fills in defaults and checks the targets
+func loadBuildFile(path string) (*BuildFile, error) {
+	var file BuildFile
+	if err := decodeConfigFile(path, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Targets) == 0 {
+		return nil, fmt.Errorf("no targets declared")
+	}
+
+	dir := filepath.Dir(path)
+	names := map[string]bool{}
+	for i, target := range file.Targets {
+		if err := target.prepare(dir); err != nil {
+			return nil, fmt.Errorf("targets[%d]: %w", i, err)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("targets[%d]: duplicate target name %q", i, target.Name)
+		}
+		names[target.Name] = true
+	}
+	return &file, nil
+}
+
+// prepare validates a target, applies defaults and makes its paths
+// relative to dir
+func (t *BuildTarget) prepare(dir string) error {
+	if len(t.Inputs) == 0 {
+		return fmt.Errorf("inputs are required")
+	}
+	if t.Output == "" {
+		return fmt.Errorf("output is required")
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(t.Output), filepath.Ext(t.Output))
+	}
+
+	if t.Format == "" {
+		t.Format = "json"
+	}
+	if t.Merge == "" {
+		t.Merge = "replace"
+	}
+	if t.IntType == "" {
+		t.IntType = "auto"
+	}
+	if t.FloatType == "" {
+		t.FloatType = "double"
+	}
+	for _, option := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"format", t.Format, inputFormats},
+		{"merge", t.Merge, mergeStrategyNames()},
+		{"int_type", t.IntType, integerTypes},
+		{"float_type", t.FloatType, floatTypes},
+	} {
+		if !containsString(option.allowed, option.value) {
+			return fmt.Errorf("invalid %s %q, expected one of: %s", option.name, option.value, joinStrings(option.allowed))
+		}
+	}
+	if t.Profile != "" && !containsString(profileNames(), t.Profile) {
+		return fmt.Errorf("invalid profile %q, expected one of: %s", t.Profile, joinStrings(profileNames()))
+	}
+	switch t.Writer.RecordSize {
+	case 0, 24, 28, 32:
+	default:
+		return fmt.Errorf("invalid writer.record_size %d, expected 24, 28 or 32", t.Writer.RecordSize)
+	}
+	switch t.Writer.IPVersion {
+	case 0, 4, 6:
+	default:
+		return fmt.Errorf("invalid writer.ip_version %d, expected 4 or 6", t.Writer.IPVersion)
+	}
+	if t.MetadataFile != "" && t.Metadata != nil {
+		return fmt.Errorf("metadata and metadata_file cannot be used together")
+	}
+	for i, check := range t.Checks {
+		if check.IP == "" {
+			return fmt.Errorf("checks[%d]: ip is required", i)
+		}
+		if check.Found != nil && !*check.Found && len(check.Expect) > 0 {
+			return fmt.Errorf("checks[%d]: expect cannot be used with found: false", i)
+		}
+	}
+
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range t.Inputs {
+		resolve(&t.Inputs[i])
+	}
+	for i := range t.RIR {
+		resolve(&t.RIR[i])
+	}
+	for _, path := range []*string{&t.Output, &t.Mapping, &t.Schema, &t.ASNames, &t.Primary, &t.MetadataFile, &t.Base, &t.SignKey} {
+		resolve(path)
+	}
+	return nil
+}
+
+// buildOptions loads the files a target refers to
+func (t *BuildTarget) buildOptions() (BuildOptions, error) {
+	settings := InputSettings{
+		Format:       t.Format,
+		MappingFile:  t.Mapping,
+		Profile:      t.Profile,
+		SchemaFile:   t.Schema,
+		MetadataFile: t.MetadataFile,
+		Metadata:     t.Metadata,
+		RIRFiles:     t.RIR,
+		ASNamesFile:  t.ASNames,
+		PrimaryFile:  t.Primary,
+		BaseFile:     t.Base,
+	}
+	if t.Data != nil {
+		data, err := listDataFromMap(t.Data)
+		if err != nil {
+			return BuildOptions{}, fmt.Errorf("invalid data: %w", err)
+		}
+		settings.ListData = data
+	}
+	input, baseIPVersion, err := loadInputOptions(settings)
+	if err != nil {
+		return BuildOptions{}, err
+	}
+	inputs, err := expandInputPaths(t.Inputs, input.Format)
+	if err != nil {
+		return BuildOptions{}, err
+	}
+
+	opts := BuildOptions{
+		Inputs:        inputs,
+		Input:         input,
+		Merge:         t.Merge,
+		Numbers:       NumberPolicy{Integer: t.IntType, Float: t.FloatType},
+		BaseFile:      t.Base,
+		BaseIPVersion: baseIPVersion,
+		Writer:        t.Writer,
+		Output:        t.Output,
+		Reproducible:  t.Reproducible || t.VerifyReproducible,
+		Checksum:      t.Checksum,
+		Manifest:      t.Manifest,
+	}
+	if len(t.Checks) > 0 {
+		opts.Check = t.check
+	}
+	if t.SignKey != "" {
+		key, err := loadSigningKey(t.SignKey)
+		if err != nil {
+			return BuildOptions{}, err
+		}
+		opts.SignKey = key
+	}
+	return opts, nil
+}
+
+// checkFailuresError is returned by the Check hook of a target whose
+// checks failed, so the build leaves the output untouched
+type checkFailuresError struct {
+	failures []string
+}
+
+func (e *checkFailuresError) Error() string {
+	return fmt.Sprintf("%d checks failed", len(e.failures))
+}
+
+// check runs the checks of a target on the database at path, it is the
+// Check hook of the target's build
+func (t *BuildTarget) check(path string) error {
+	failures, err := t.runChecks(path)
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return &checkFailuresError{failures: failures}
+	}
+	return nil
+}
+
+// runChecks looks up the checks of a target in the database at path and
+// returns the failed ones
+func (t *BuildTarget) runChecks(path string) ([]string, error) {
+	if len(t.Checks) == 0 {
+		return nil, nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening MMDB file: %w", err)
+	}
+	defer reader.Close()
+
+	var failures []string
+	for _, check := range t.Checks {
+		paths := make([]string, 0, len(check.Expect))
+		for path := range check.Expect {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fields, err := parseFieldPaths(paths)
+		if err != nil {
+			return nil, err
+		}
+
+		result := lookupIP(reader, check.IP, fields)
+		wantFound := check.Found == nil || *check.Found
+		var problems []string
+		switch {
+		case result.Error != "":
+			problems = append(problems, result.Error)
+		case !result.Found && wantFound:
+			problems = append(problems, "not found")
+		case result.Found && !wantFound:
+			problems = append(problems, fmt.Sprintf("found in %s, expected no record", result.Network))
+		case result.Found:
+			for _, field := range fields {
+				got := formatFieldValue(result.Fields[field.Name])
+				want := formatFieldValue(check.Expect[field.Name])
+				if got != want {
+					problems = append(problems, fmt.Sprintf("%s is %q, expected %q", field.Name, got, want))
+				}
+			}
+		}
+		if len(problems) > 0 {
+			failures = append(failures, fmt.Sprintf("%s: %s", check.IP, strings.Join(problems, ", ")))
+		}
+	}
+	return failures, nil
+}
+
+// buildTargetResult is the outcome of one target for the summary
+type buildTargetResult struct {
+	target   *BuildTarget
+	result   BuildResult
+	err      error
+	failures []string
+}
+
+// runBuildCommand implements `mmdbimport build`, building all (or the
+// selected) targets of a build file. A failed target does not stop the
+// others, the exit code is 1 if any target failed.
+func runBuildCommand(args []string) {
+	app := kingpin.New("mmdbimport build", "Build the databases declared in a build file")
+	app.HelpFlag.Short('h')
+	app.UsageWriter(os.Stdout)
+
+	buildFile := app.Flag("file", "Build file (JSON or YAML) declaring the targets").
+		Short('f').
+		Default("mmdbbuild.yaml").
+		ExistingFile()
+
+	targetNames := app.Flag("target", "Only build this target (repeatable)").
+		Short('t').
+		Strings()
+
+	kingpin.MustParse(app.Parse(args))
+
+	file, err := loadBuildFile(*buildFile)
+	if err != nil {
+		log.Fatal(errorColor(fmt.Sprintf("Error loading build file: %v", err)))
+	}
+
+	targets := file.Targets
+	if len(*targetNames) > 0 {
+		byName := map[string]*BuildTarget{}
+		for _, target := range file.Targets {
+			byName[target.Name] = target
+		}
+		targets = nil
+		for _, name := range *targetNames {
+			target, ok := byName[name]
+			if !ok {
+				log.Fatal(errorColor(fmt.Sprintf("Unknown target %q", name)))
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	var results []buildTargetResult
+	for _, target := range targets {
+		fmt.Printf("\n%s %s\n", infoColor("==> Building target"), target.Name)
+		outcome := buildTargetResult{target: target}
+		opts, err := target.buildOptions()
+		if err == nil {
+			if target.VerifyReproducible {
+				outcome.result, err = verifyReproducibleBuild(opts)
+			} else {
+				outcome.result, err = buildDatabase(opts)
+			}
+		}
+		var checkErr *checkFailuresError
+		if errors.As(err, &checkErr) {
+			outcome.failures, err = checkErr.failures, nil
+		}
+		outcome.err = err
+		results = append(results, outcome)
+	}
+
+	if !printBuildSummary(results) {
+		os.Exit(1)
+	}
+}
+
+// printBuildSummary prints one line per target and the failed checks, it
+// reports whether all targets succeeded
+func printBuildSummary(results []buildTargetResult) bool {
+	fmt.Printf("\n%s\n", infoColor("Build summary:"))
+	ok := true
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			ok = false
+			fmt.Printf("  %s %s: %s\n", errorColor("✗"), r.target.Name, errorColor(r.err.Error()))
+		case len(r.failures) > 0:
+			ok = false
+			fmt.Printf("  %s %s: %s not replaced, %d of %d checks failed\n", errorColor("✗"), r.target.Name, r.result.Output, len(r.failures), len(r.target.Checks))
+			for _, failure := range r.failures {
+				fmt.Printf("      %s\n", failure)
+			}
+		default:
+			reproducible := ""
+			if r.result.SHA256 != "" {
+				reproducible = ", reproducible"
+			}
+			fmt.Printf("  %s %s: %s, %d records, IPv%d, %d checks passed%s\n", successColor("✓"), r.target.Name, r.result.Output,
+				r.result.Records, r.result.IPVersion, len(r.target.Checks), reproducible)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildTargetChecks(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	output := filepath.Join(dir, "test.mmdb")
+	buildFile := filepath.Join(dir, "mmdbbuild.yaml")
+	if err := os.WriteFile(buildFile, []byte(`targets:
+  - inputs: [input.json]
+    output: test.mmdb
+    checksum: true
+    checks:
+      - ip: 1.0.0.1
+        expect:
+          a: 1
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(value string) error {
+		if err := os.WriteFile(input, []byte(`{"metadata":{"database_type":"Test","description":{"en":"test"},"build_epoch":1},"records":[{"network":"1.0.0.0/24","data":{"a":`+value+`}}]}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := loadBuildFile(buildFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := file.Targets[0].buildOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = buildDatabase(opts)
+		return err
+	}
+
+	if err := build("1"); err != nil {
+		t.Fatal(err)
+	}
+	database, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := os.ReadFile(output + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed check leaves the database and its checksum as they were
+	err = build("2")
+	var checkErr *checkFailuresError
+	if !errors.As(err, &checkErr) {
+		t.Fatalf("error = %v, want the failed checks", err)
+	}
+	if want := []string{`1.0.0.1: a is "2", expected "1"`}; !reflect.DeepEqual(checkErr.failures, want) {
+		t.Errorf("failures = %q, want %q", checkErr.failures, want)
+	}
+	for path, want := range map[string][]byte{output: database, output + ".sha256": checksum} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s was replaced by the failed build", filepath.Base(path))
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("%d files in the output directory, want input, build file, database and checksum", len(entries))
+	}
+}
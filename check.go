@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// maxOverlapDetails limits how many overlaps the human output lists, the
+// JSON output always has all of them.
+const maxOverlapDetails = 100
+
+// CheckOutput is the JSON output of check mode
+type CheckOutput struct {
+	// Filepath is the first input.
+	// Deprecated: use Filepaths, which lists all inputs.
+	Filepath     string            `json:"filepath"`
+	Filepaths    []string          `json:"filepaths"`
+	Valid        bool              `json:"valid"`
+	IPVersion    int               `json:"ip_version"`
+	TotalRecords int               `json:"total_records"`
+	Errors       []ValidationError `json:"errors"`
+	Overlaps     OverlapReport     `json:"overlaps"`
+}
+
+// checkInputFile validates the input files and reports how their records
+// overlap each other. Overlaps are reported as warnings, only validation
+// errors make the check fail.
+func checkInputFile(paths []string, opts InputOptions, jsonOutput bool) error {
+	index := &OverlapIndex{}
+	summary, ve, err := scanInputFile(paths, opts, index)
+	if err != nil {
+		log.Printf("%s: Error reading input file: %v", errorColor("Error"), err)
+		return err
+	}
+	report := index.Report()
+	if err := locateOverlaps(paths, opts, &report); err != nil {
+		log.Printf("%s: Error reading input file: %v", errorColor("Error"), err)
+		return err
+	}
+	names := opts.inputNames(paths)
+
+	if jsonOutput {
+		output := CheckOutput{
+			Filepath:     names[0],
+			Filepaths:    names,
+			Valid:        !ve.HasErrors(),
+			IPVersion:    summary.IPVersion,
+			TotalRecords: summary.Records,
+			Errors:       ve.Errors,
+			Overlaps:     report,
+		}
+		if output.Errors == nil {
+			output.Errors = []ValidationError{}
+		}
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling JSON: %w", err)
+		}
+		fmt.Printf("%s\n", string(jsonData))
+		if !output.Valid {
+			return fmt.Errorf("validation failed")
+		}
+		return nil
+	}
+
+	validationErr := printInputSummary(names, summary, ve)
+	printOverlapReport(report)
+	if validationErr != nil {
+		return validationErr
+	}
+
+	fmt.Printf("%s %s\n", successColor("✓"), infoColor("JSON validation successful"))
+	return nil
+}
+
+func printOverlapReport(report OverlapReport) {
+	fmt.Printf("\n%s\n", infoColor("Overlaps:"))
+	if report.Total() == 0 {
+		fmt.Printf("  %s\n", successColor("No overlapping networks"))
+		return
+	}
+
+	fmt.Printf("  Duplicates: %s\n", warnColor(fmt.Sprintf("%d", report.Duplicates)))
+	fmt.Printf("  Shadowed: %s\n", warnColor(fmt.Sprintf("%d", report.Shadowed)))
+	fmt.Printf("  Partial (different data): %s\n", warnColor(fmt.Sprintf("%d", report.Partial)))
+
+	for i, overlap := range report.Overlaps {
+		if i == maxOverlapDetails {
+			fmt.Printf("  ... and %d more, use --json to list all\n", len(report.Overlaps)-maxOverlapDetails)
+			break
+		}
+
+		sameData := ""
+		if overlap.SameData {
+			sameData = " (same data)"
+		}
+		switch overlap.Kind {
+		case "duplicate":
+			fmt.Printf("  %s %s %s is repeated by %s%s\n",
+				warnColor("duplicate:"), overlap.Location, overlap.Network, overlap.OtherLocation, sameData)
+		case "shadowed":
+			fmt.Printf("  %s %s %s is fully covered by %s %s%s\n",
+				warnColor("shadowed:"), overlap.Location, overlap.Network, overlap.OtherLocation, overlap.OtherNetwork, sameData)
+		case "partial":
+			fmt.Printf("  %s %s %s is partly overridden by %s %s\n",
+				warnColor("partial:"), overlap.Location, overlap.Network, overlap.OtherLocation, overlap.OtherNetwork)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// cloudFormats are the published IP range files of cloud providers, keyed
+// by input format
+var cloudFormats = map[string]func(data []byte) ([]cloudPrefix, error){
+	"aws":    parseAWSRanges,
+	"gcp":    parseGCPRanges,
+	"azure":  parseAzureServiceTags,
+	"oracle": parseOracleRanges,
+}
+
+var cloudProviderNames = map[string]string{
+	"aws":    "AWS",
+	"gcp":    "Google Cloud",
+	"azure":  "Azure",
+	"oracle": "Oracle Cloud",
+}
+
+// cloudPrefix is one prefix of a range file with the region and services
+// it is published for
+type cloudPrefix struct {
+	location string
+	prefix   string
+	region   string
+	services []string
+}
+
+// parseAWSRanges reads ip-ranges.json,
+// {"prefixes": [{"ip_prefix", "region", "service"}], "ipv6_prefixes": [{"ipv6_prefix", ...}]}
+func parseAWSRanges(data []byte) ([]cloudPrefix, error) {
+	var file struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for i, p := range file.Prefixes {
+		prefixes = append(prefixes, cloudPrefix{
+			location: fmt.Sprintf("prefixes[%d]", i),
+			prefix:   p.IPPrefix,
+			region:   p.Region,
+			services: []string{p.Service},
+		})
+	}
+	for i, p := range file.IPv6Prefixes {
+		prefixes = append(prefixes, cloudPrefix{
+			location: fmt.Sprintf("ipv6_prefixes[%d]", i),
+			prefix:   p.IPv6Prefix,
+			region:   p.Region,
+			services: []string{p.Service},
+		})
+	}
+	return prefixes, nil
+}
+
+// parseGCPRanges reads cloud.json,
+// {"prefixes": [{"ipv4Prefix" or "ipv6Prefix", "service", "scope"}]}
+func parseGCPRanges(data []byte) ([]cloudPrefix, error) {
+	var file struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for i, p := range file.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+		prefixes = append(prefixes, cloudPrefix{
+			location: fmt.Sprintf("prefixes[%d]", i),
+			prefix:   prefix,
+			region:   p.Scope,
+			services: []string{p.Service},
+		})
+	}
+	return prefixes, nil
+}
+
+// parseAzureServiceTags reads ServiceTags_*.json,
+// {"values": [{"name", "properties": {"region", "systemService", "addressPrefixes"}}]}.
+// Tags without a system service, like AzureCloud.eastus, use the tag name
+// without the region as service.
+func parseAzureServiceTags(data []byte) ([]cloudPrefix, error) {
+	var file struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for i, tag := range file.Values {
+		service := tag.Properties.SystemService
+		if service == "" {
+			service, _, _ = strings.Cut(tag.Name, ".")
+		}
+		for j, prefix := range tag.Properties.AddressPrefixes {
+			prefixes = append(prefixes, cloudPrefix{
+				location: fmt.Sprintf("values[%d].properties.addressPrefixes[%d]", i, j),
+				prefix:   prefix,
+				region:   tag.Properties.Region,
+				services: []string{service},
+			})
+		}
+	}
+	return prefixes, nil
+}
+
+// parseOracleRanges reads public_ip_ranges.json,
+// {"regions": [{"region", "cidrs": [{"cidr", "tags"}]}]}
+func parseOracleRanges(data []byte) ([]cloudPrefix, error) {
+	var file struct {
+		Regions []struct {
+			Region string `json:"region"`
+			CIDRs  []struct {
+				CIDR string   `json:"cidr"`
+				Tags []string `json:"tags"`
+			} `json:"cidrs"`
+		} `json:"regions"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for i, region := range file.Regions {
+		for j, cidr := range region.CIDRs {
+			prefixes = append(prefixes, cloudPrefix{
+				location: fmt.Sprintf("regions[%d].cidrs[%d]", i, j),
+				prefix:   cidr.CIDR,
+				region:   region.Region,
+				services: cidr.Tags,
+			})
+		}
+	}
+	return prefixes, nil
+}
+
+// cloudRecordReader turns a cloud range file into {"provider", "region",
+// "service"} records. A prefix listed several times becomes one record,
+// region and service hold the first value and the arrays regions and
+// services all of them, so every field always has the same type.
+type cloudRecordReader struct {
+	provider string
+	prefixes []netip.Prefix
+	entries  map[netip.Prefix]*cloudEntry
+	errors   []*ValidationError
+	index    int
+	location string
+}
+
+type cloudEntry struct {
+	regions  []string
+	services []string
+}
+
+func newCloudRecordReader(path string, provider string) (*cloudRecordReader, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	cloudPrefixes, err := cloudFormats[provider](data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s ranges: %w", provider, err)
+	}
+
+	r := &cloudRecordReader{provider: provider, entries: map[netip.Prefix]*cloudEntry{}}
+	for _, p := range cloudPrefixes {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(p.prefix))
+		if err != nil {
+			r.errors = append(r.errors, &ValidationError{
+				Field:   p.location,
+				Message: fmt.Sprintf("invalid CIDR format: %v", err),
+			})
+			continue
+		}
+		prefix = prefix.Masked()
+
+		entry, ok := r.entries[prefix]
+		if !ok {
+			entry = &cloudEntry{}
+			r.entries[prefix] = entry
+			r.prefixes = append(r.prefixes, prefix)
+		}
+		entry.regions = appendUnique(entry.regions, p.region)
+		for _, service := range p.services {
+			entry.services = appendUnique(entry.services, service)
+		}
+	}
+
+	// Less specific prefixes first, so more specific ones override them
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return r.prefixes[i].Bits() < r.prefixes[j].Bits()
+	})
+	return r, nil
+}
+
+// appendUnique appends a non-empty value that is not in the list yet
+func appendUnique(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func (r *cloudRecordReader) Metadata() Metadata {
+	return Metadata{
+		DatabaseType: "Cloud-IP-Ranges",
+		Description:  map[string]string{"en": cloudProviderNames[r.provider] + " IP ranges imported from the published range file"},
+	}
+}
+
+func (r *cloudRecordReader) Next() (JSONRecord, error) {
+	if len(r.errors) > 0 {
+		err := r.errors[0]
+		r.errors = r.errors[1:]
+		r.location = err.Field
+		return JSONRecord{}, err
+	}
+	if r.index >= len(r.prefixes) {
+		return JSONRecord{}, io.EOF
+	}
+
+	prefix := r.prefixes[r.index]
+	r.index++
+	r.location = "prefix " + prefix.String()
+
+	entry := r.entries[prefix]
+	data := map[string]any{"provider": r.provider}
+	if len(entry.regions) > 0 {
+		data["region"] = entry.regions[0]
+		data["regions"] = stringArray(entry.regions)
+	}
+	if len(entry.services) > 0 {
+		data["service"] = entry.services[0]
+		data["services"] = stringArray(entry.services)
+	}
+	return JSONRecord{Network: prefix.String(), Data: data}, nil
+}
+
+func stringArray(values []string) []any {
+	array := make([]any, len(values))
+	for i, v := range values {
+		array[i] = v
+	}
+	return array
+}
+
+func (r *cloudRecordReader) Location() string {
+	return r.location
+}
+
+func (r *cloudRecordReader) Close() error {
+	return nil
+}
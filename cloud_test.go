@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCloudRecordReaderMergesServices(t *testing.T) {
+	path := writeTestFile(t, "ip-ranges.json", `{"prefixes": [
+		{"ip_prefix": "1.0.0.0/16", "region": "us-east-1", "service": "AMAZON"},
+		{"ip_prefix": "1.0.0.0/16", "region": "us-east-1", "service": "EC2"},
+		{"ip_prefix": "1.0.1.0/24", "region": "eu-west-1", "service": "AMAZON"}
+	]}`)
+	reader, err := newCloudRecordReader(path, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]any{
+		{"provider": "aws", "region": "us-east-1", "regions": []any{"us-east-1"}, "service": "AMAZON", "services": []any{"AMAZON", "EC2"}},
+		{"provider": "aws", "region": "eu-west-1", "regions": []any{"eu-west-1"}, "service": "AMAZON", "services": []any{"AMAZON"}},
+	}
+	for _, data := range want {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(record.Data, data) {
+			t.Errorf("%s: got %v, want %v", record.Network, record.Data, data)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
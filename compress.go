@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compression is a compressed input format
+type compression struct {
+	name       string
+	magic      []byte
+	extensions []string
+	open       func(r io.Reader) (io.Reader, func(), error)
+}
+
+// compressions are the compressed input formats, recognised by their magic
+// bytes or, failing that, by the file extension
+var compressions = []compression{
+	{"gzip", []byte{0x1f, 0x8b}, []string{".gz", ".gzip"}, func(r io.Reader) (io.Reader, func(), error) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() { zr.Close() }, nil
+	}},
+	{"bzip2", []byte("BZh"), []string{".bz2"}, func(r io.Reader) (io.Reader, func(), error) {
+		return bzip2.NewReader(r), func() {}, nil
+	}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, []string{".zst", ".zstd"}, func(r io.Reader) (io.Reader, func(), error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, []string{".xz"}, func(r io.Reader) (io.Reader, func(), error) {
+		zr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() {}, nil
+	}},
+}
+
+// compressedFile is an input file read through a decompressor
+type compressedFile struct {
+	io.Reader
+	file  *os.File
+	close func()
+}
+
+func (c *compressedFile) Close() error {
+	c.close()
+	return c.file.Close()
+}
+
+// openInput opens an input file for reading, transparently decompressing
+// gzip, bzip2, zstd and xz files.
+func openInput(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReaderSize(f, 1<<16)
+	// Peek fails for files shorter than the magic bytes, which are then
+	// read as they are
+	head, _ := buffered.Peek(8)
+	c, ok := detectCompression(head, path)
+	if !ok {
+		return &compressedFile{Reader: buffered, file: f, close: func() {}}, nil
+	}
+	r, closeReader, err := c.open(buffered)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s input %s: %w", c.name, path, err)
+	}
+	return &compressedFile{Reader: r, file: f, close: closeReader}, nil
+}
+
+// detectCompression finds the compression of a file from its first bytes,
+// or from the extension of path when no magic bytes match. A misleading
+// extension does not win over the content.
+func detectCompression(head []byte, path string) (compression, bool) {
+	for _, c := range compressions {
+		if bytes.HasPrefix(head, c.magic) {
+			return c, true
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, c := range compressions {
+		if containsString(c.extensions, ext) {
+			return c, true
+		}
+	}
+	return compression{}, false
+}
+
+// readInput reads a whole, possibly compressed, input file
+func readInput(path string) ([]byte, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// spoolStdin copies stdin into a temporary file, as input is read twice,
+// once to validate it and once to build the database. The caller removes
+// the file.
+func spoolStdin() (string, error) {
+	f, err := os.CreateTemp("", "mmdbimport-stdin-*")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file for stdin: %w", err)
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("reading stdin: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing temporary file for stdin: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const compressTestContent = `{"network":"1.0.0.0/24","data":{"a":1}}` + "\n"
+
+// compressTestData returns compressTestContent compressed with the named
+// compression
+func compressTestData(t *testing.T, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch name {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		io.WriteString(w, compressTestContent)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, compressTestContent)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "xz":
+		w, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, compressTestContent)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "bzip2":
+		// The standard library has no bzip2 writer, this is the output of
+		// bzip2 -9 for compressTestContent
+		data, err := hex.DecodeString("425a683931415926535946cb7804000012d98000101005f4102609948a20003140d34323262109a068c807a82d7cb90582bb9b1c55f1122f6939e40341580dfc5dc914e142411b2de010")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	default:
+		return []byte(compressTestContent)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenInput(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		file        string
+		wantErr     string
+	}{
+		{name: "plain", file: "input.jsonl"},
+		{name: "gzip by extension", compression: "gzip", file: "input.jsonl.gz"},
+		{name: "gzip by magic bytes", compression: "gzip", file: "input.jsonl"},
+		{name: "bzip2 by extension", compression: "bzip2", file: "input.jsonl.bz2"},
+		{name: "bzip2 by magic bytes", compression: "bzip2", file: "input"},
+		{name: "zstd by extension", compression: "zstd", file: "input.jsonl.zst"},
+		{name: "zstd by magic bytes", compression: "zstd", file: "input.jsonl"},
+		{name: "xz by extension", compression: "xz", file: "input.jsonl.XZ"},
+		{name: "xz by magic bytes", compression: "xz", file: "input.jsonl"},
+		{name: "xz with a gzip extension", compression: "xz", file: "input.jsonl.gz"},
+		{name: "zstd with a bzip2 extension", compression: "zstd", file: "input.bz2"},
+		{name: "plain file with a gzip extension", file: "input.jsonl.gz", wantErr: "reading gzip input"},
+		{name: "plain file with an xz extension", file: "input.xz", wantErr: "reading xz input"},
+		{name: "empty plain file", file: "empty.jsonl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := compressTestData(t, tt.compression)
+			want := compressTestContent
+			if strings.HasPrefix(tt.file, "empty") {
+				data, want = nil, ""
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readInput(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSpoolStdin(t *testing.T) {
+	for _, compression := range []string{"", "gzip", "bzip2", "zstd", "xz"} {
+		t.Run(compression, func(t *testing.T) {
+			stdin := writeTestFile(t, "stdin", string(compressTestData(t, compression)))
+			f, err := os.Open(stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+			os.Stdin = f
+
+			spool, err := spoolStdin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(spool)
+
+			// The spool file has no extension, so only the magic bytes
+			// tell the compression
+			got, err := readInput(spool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != compressTestContent {
+				t.Errorf("got %q, want %q", got, compressTestContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// CSVMapping describes how the columns of a CSV or TSV file become records.
+//
+// Example (YAML):
+//
+//	network: network
+//	empty: omit
+//	columns:
+//	  - column: country
+//	    field: country.iso_code
+//	  - column: latitude
+//	    field: location.latitude
+//	    type: double
+type CSVMapping struct {
+	// Network is the column holding the network, "network" by default
+	Network string `json:"network" yaml:"network"`
+	// StartIP and EndIP are the columns holding a range, instead of Network
+	StartIP string `json:"start_ip" yaml:"start_ip"`
+	EndIP   string `json:"end_ip" yaml:"end_ip"`
+	// IntegerIPs is set when StartIP and EndIP hold addresses as decimal
+	// integers. Ranges ending at most at 2^32-1 are IPv4, others IPv6.
+	IntegerIPs bool `json:"integer_ips" yaml:"integer_ips"`
+	// NullValues are cell values handled like empty cells, e.g. "-"
+	NullValues []string `json:"null_values" yaml:"null_values"`
+	// SkipNetworks lists networks whose rows are skipped
+	SkipNetworks []string `json:"skip_networks" yaml:"skip_networks"`
+	// NoHeader is set when the file has no header row, columns are then
+	// referred to by their 1-based position
+	NoHeader bool `json:"no_header" yaml:"no_header"`
+	// Empty is how empty cells are handled: "omit" (default) leaves the
+	// field out, "empty" keeps an empty string, "skip" skips the row and
+	// "error" rejects it
+	Empty   string       `json:"empty" yaml:"empty"`
+	Columns []*CSVColumn `json:"columns" yaml:"columns"`
+	// Metadata is used unless --metadata is given
+	Metadata *Metadata `json:"metadata" yaml:"metadata"`
+
+	skipPrefixes []netip.Prefix
+}
+
+// CSVColumn maps one column to a data field
+type CSVColumn struct {
+	Column string `json:"column" yaml:"column"`
+	// Field is a dotted data path like location.latitude
+	Field string `json:"field" yaml:"field"`
+	// Type is an MMDB type name (see typedValueTypes), "number" to type
+	// the value by --int-type and --float-type, or "string" (default)
+	Type string `json:"type" yaml:"type"`
+	// Empty overrides the empty cell handling of the mapping
+	Empty string `json:"empty" yaml:"empty"`
+}
+
+var csvEmptyModes = []string{"omit", "empty", "skip", "error"}
+
+func loadCSVMapping(path string) (*CSVMapping, error) {
+	mapping := &CSVMapping{}
+	if err := decodeConfigFile(path, mapping); err != nil {
+		return nil, err
+	}
+	if err := mapping.prepare(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// prepare checks a mapping and fills in its defaults
+func (mapping *CSVMapping) prepare() error {
+	switch {
+	case mapping.StartIP != "" || mapping.EndIP != "":
+		if mapping.StartIP == "" || mapping.EndIP == "" {
+			return fmt.Errorf("start_ip and end_ip must both be set")
+		}
+		if mapping.Network != "" {
+			return fmt.Errorf("use either network or start_ip and end_ip")
+		}
+	case mapping.Network == "":
+		mapping.Network = "network"
+	}
+	if mapping.Empty == "" {
+		mapping.Empty = "omit"
+	}
+	if !containsString(csvEmptyModes, mapping.Empty) {
+		return fmt.Errorf("unsupported empty %q, expected one of: %s", mapping.Empty, joinStrings(csvEmptyModes))
+	}
+	if len(mapping.Columns) == 0 {
+		return fmt.Errorf("mapping declares no columns")
+	}
+
+	fields := make(map[string]bool, len(mapping.Columns))
+	for i, column := range mapping.Columns {
+		if column.Column == "" {
+			return fmt.Errorf("columns[%d]: column is required", i)
+		}
+		if column.Field == "" {
+			return fmt.Errorf("columns[%d]: field is required", i)
+		}
+		for _, part := range strings.Split(column.Field, ".") {
+			if part == "" {
+				return fmt.Errorf("columns[%d]: invalid field %q", i, column.Field)
+			}
+		}
+		if column.Type == "" {
+			column.Type = "string"
+		}
+		if _, ok := typedValueKeys[column.Type]; !ok && column.Type != "number" {
+			return fmt.Errorf("columns[%d]: unsupported type %q, expected number or one of: %s", i, column.Type, joinStrings(typedValueTypes()))
+		}
+		if column.Empty == "" {
+			column.Empty = mapping.Empty
+		}
+		if !containsString(csvEmptyModes, column.Empty) {
+			return fmt.Errorf("columns[%d]: unsupported empty %q, expected one of: %s", i, column.Empty, joinStrings(csvEmptyModes))
+		}
+		fields[column.Field] = true
+	}
+
+	// A field cannot also be a map holding other fields
+	for field := range fields {
+		for prefix := field; strings.Contains(prefix, "."); {
+			prefix = prefix[:strings.LastIndexByte(prefix, '.')]
+			if fields[prefix] {
+				return fmt.Errorf("field %q is nested in field %q", field, prefix)
+			}
+		}
+	}
+	if len(fields) != len(mapping.Columns) {
+		return fmt.Errorf("fields must be mapped only once")
+	}
+
+	if mapping.IntegerIPs && mapping.StartIP == "" {
+		return fmt.Errorf("integer_ips requires start_ip and end_ip")
+	}
+	mapping.skipPrefixes = nil
+	for _, network := range mapping.SkipNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return fmt.Errorf("skip_networks: %w", err)
+		}
+		mapping.skipPrefixes = append(mapping.skipPrefixes, prefix.Masked())
+	}
+
+	return nil
+}
+
+// csvRecordReader turns the rows of a CSV or TSV file into records. TSV has
+// no quoting, every line is split at its tabs.
+type csvRecordReader struct {
+	file    io.ReadCloser
+	reader  *csv.Reader
+	tsv     *bufio.Reader
+	mapping *CSVMapping
+	// network, start, end and columns are the indices of the mapped
+	// columns, -1 when not mapped
+	network int
+	start   int
+	end     int
+	columns []int
+	line    int
+}
+
+func newCSVRecordReader(filepath string, comma rune, mapping *CSVMapping) (*csvRecordReader, error) {
+	if mapping == nil {
+		return nil, fmt.Errorf("a --mapping file is required for CSV and TSV input")
+	}
+
+	f, err := openInput(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+
+	r := &csvRecordReader{file: f, mapping: mapping}
+	if comma == '\t' {
+		r.tsv = bufio.NewReaderSize(f, 1<<20)
+	} else {
+		r.reader = csv.NewReader(f)
+		r.reader.Comma = comma
+		r.reader.FieldsPerRecord = -1
+		r.reader.ReuseRecord = true
+	}
+
+	header := map[string]int{}
+	if !mapping.NoHeader {
+		row, err := r.readRow()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("file has no header row")
+			}
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+		for i, name := range row {
+			header[strings.TrimSpace(name)] = i
+		}
+	}
+
+	r.network, r.start, r.end = -1, -1, -1
+	if mapping.Network != "" {
+		r.network, err = r.columnIndex(header, mapping.Network)
+	} else if r.start, err = r.columnIndex(header, mapping.StartIP); err == nil {
+		r.end, err = r.columnIndex(header, mapping.EndIP)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	for _, column := range mapping.Columns {
+		index, err := r.columnIndex(header, column.Column)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.columns = append(r.columns, index)
+	}
+
+	return r, nil
+}
+
+func (r *csvRecordReader) columnIndex(header map[string]int, column string) (int, error) {
+	if r.mapping.NoHeader {
+		position, err := strconv.Atoi(column)
+		if err != nil || position < 1 {
+			return 0, fmt.Errorf("column %q must be a 1-based position, the file has no header", column)
+		}
+		return position - 1, nil
+	}
+	index, ok := header[column]
+	if !ok {
+		return 0, fmt.Errorf("column %q not found in header", column)
+	}
+	return index, nil
+}
+
+func (r *csvRecordReader) Metadata() Metadata {
+	if r.mapping.Metadata == nil {
+		return Metadata{}
+	}
+	return *r.mapping.Metadata
+}
+
+// readRow returns the cells of the next non-empty row and sets the line
+// number it started on.
+func (r *csvRecordReader) readRow() ([]string, error) {
+	if r.tsv == nil {
+		row, err := r.reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				r.line = parseErr.StartLine
+			}
+			return nil, err
+		}
+		r.line, _ = r.reader.FieldPos(0)
+		return row, nil
+	}
+
+	for {
+		line, err := r.tsv.ReadString('\n')
+		if line == "" && err != nil {
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return strings.Split(line, "\t"), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *csvRecordReader) Next() (JSONRecord, error) {
+	for {
+		record, skip, err := r.nextRow()
+		if err != nil || !skip {
+			return record, err
+		}
+	}
+}
+
+// nextRow reads the next row, skip is set when the mapping skips it
+func (r *csvRecordReader) nextRow() (record JSONRecord, skip bool, err error) {
+	row, err := r.readRow()
+	if err == io.EOF {
+		return JSONRecord{}, false, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return JSONRecord{}, false, r.rowError(fmt.Sprintf("invalid row: %v", parseErr.Err))
+		}
+		return JSONRecord{}, false, fmt.Errorf("reading line %d: %w", r.line+1, err)
+	}
+
+	if r.network >= len(row) || r.start >= len(row) || r.end >= len(row) {
+		return JSONRecord{}, false, r.rowError(fmt.Sprintf("row has %d columns, network column is missing", len(row)))
+	}
+	record = JSONRecord{Data: map[string]any{}}
+	for i, column := range r.mapping.Columns {
+		cell := ""
+		if r.columns[i] < len(row) {
+			cell = strings.TrimSpace(row[r.columns[i]])
+		}
+		if containsString(r.mapping.NullValues, cell) {
+			cell = ""
+		}
+
+		if cell == "" {
+			switch column.Empty {
+			case "omit":
+				continue
+			case "skip":
+				return JSONRecord{}, true, nil
+			case "error":
+				return JSONRecord{}, false, r.rowError(fmt.Sprintf("column %s is empty", column.Column))
+			}
+			setDataPath(record.Data, column.Field, "")
+			continue
+		}
+
+		value, err := csvCellValue(cell, column.Type)
+		if err != nil {
+			return JSONRecord{}, false, r.rowError(fmt.Sprintf("column %s: %v", column.Column, err))
+		}
+		setDataPath(record.Data, column.Field, value)
+	}
+
+	if r.network >= 0 {
+		record.Network = strings.TrimSpace(row[r.network])
+	} else {
+		record.StartIP = strings.TrimSpace(row[r.start])
+		record.EndIP = strings.TrimSpace(row[r.end])
+		if r.mapping.IntegerIPs {
+			if record.StartIP, record.EndIP, err = integerRange(record.StartIP, record.EndIP); err != nil {
+				return JSONRecord{}, false, r.rowError(err.Error())
+			}
+		}
+	}
+
+	if len(r.mapping.skipPrefixes) > 0 {
+		if network, err := recordRange(record); err == nil {
+			for _, prefix := range r.mapping.skipPrefixes {
+				if prefix.Contains(network.From()) && prefix.Contains(network.To()) {
+					return JSONRecord{}, true, nil
+				}
+			}
+		}
+	}
+
+	return record, false, nil
+}
+
+func (r *csvRecordReader) rowError(message string) error {
+	return &ValidationError{
+		Field:   r.Location(),
+		Message: message,
+	}
+}
+
+func (r *csvRecordReader) Location() string {
+	return fmt.Sprintf("line %d", r.line)
+}
+
+func (r *csvRecordReader) Close() error {
+	return r.file.Close()
+}
+
+// csvCellValue turns a cell into a data value. Typed columns become typed
+// value wrappers, so they are checked and converted like $type values.
+func csvCellValue(cell string, mmdbType string) (any, error) {
+	switch mmdbType {
+	case "string":
+		return cell, nil
+	case "number":
+		var value any
+		if err := unmarshalJSON([]byte(cell), &value); err != nil {
+			return nil, fmt.Errorf("%q is not a number", cell)
+		}
+		n, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a number", cell)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", cell)
+		}
+		return b, nil
+	case "bytes":
+		return map[string]any{typeKey: mmdbType, "base64": cell}, nil
+	default:
+		return map[string]any{typeKey: mmdbType, "value": cell}, nil
+	}
+}
+
+// integerRange converts a range given as decimal integers into addresses.
+// The end decides the IP version, so a range starting at 0 in an IPv6 file
+// stays IPv6. IPv4-mapped IPv6 ranges are returned as IPv4.
+func integerRange(start, end string) (string, string, error) {
+	from, ok := new(big.Int).SetString(start, 10)
+	if !ok || from.Sign() < 0 || from.Cmp(maxUint128) > 0 {
+		return "", "", fmt.Errorf("invalid integer IP %q", start)
+	}
+	to, ok := new(big.Int).SetString(end, 10)
+	if !ok || to.Sign() < 0 || to.Cmp(maxUint128) > 0 {
+		return "", "", fmt.Errorf("invalid integer IP %q", end)
+	}
+
+	if to.Cmp(maxUint32) <= 0 {
+		var a, b [4]byte
+		from.FillBytes(a[:])
+		to.FillBytes(b[:])
+		return netip.AddrFrom4(a).String(), netip.AddrFrom4(b).String(), nil
+	}
+
+	var a, b [16]byte
+	from.FillBytes(a[:])
+	to.FillBytes(b[:])
+	fromAddr, toAddr := netip.AddrFrom16(a), netip.AddrFrom16(b)
+	if fromAddr.Is4In6() && toAddr.Is4In6() {
+		fromAddr, toAddr = fromAddr.Unmap(), toAddr.Unmap()
+	}
+	return fromAddr.String(), toAddr.String(), nil
+}
+
+// setDataPath sets a dotted path in data, creating the maps in between
+func setDataPath(data map[string]any, path string, value any) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := data[part].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			data[part] = next
+		}
+		data = next
+	}
+	data[parts[len(parts)-1]] = value
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntegerRange(t *testing.T) {
+	tests := []struct {
+		start, end string
+		wantStart  string
+		wantEnd    string
+		wantErr    bool
+	}{
+		{"16777216", "16777471", "1.0.0.0", "1.0.0.255", false},
+		{"0", "4294967295", "0.0.0.0", "255.255.255.255", false},
+		// IPv4-mapped IPv6 ranges, as written by IP2Location DB-IPV6 files
+		{"281470698520576", "281470698520831", "1.0.0.0", "1.0.0.255", false},
+		{"281470681743360", "281474976710655", "0.0.0.0", "255.255.255.255", false},
+		// A range starting at 0 stays IPv6 when the end is IPv6
+		{"0", "281470681743359", "::", "::fffe:ffff:ffff", false},
+		{"42540766411282592856903984951653826560", "42540766411282592875350729025363378175", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", false},
+		{"340282366920938463463374607431768211455", "340282366920938463463374607431768211455", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", false},
+		{"0", "340282366920938463463374607431768211456", "", "", true},
+		{"-1", "5", "", "", true},
+		{"1.0.0.0", "5", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.start+"-"+tt.end, func(t *testing.T) {
+			start, end, err := integerRange(tt.start, tt.end)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s-%s", start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("got %s-%s, want %s-%s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCSVMappingPrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		wantErr string
+	}{
+		{name: "defaults", mapping: "columns:\n  - column: name\n    field: name\n"},
+		{name: "range columns", mapping: "start_ip: from\nend_ip: to\ncolumns:\n  - column: name\n    field: name\n"},
+		{name: "start_ip without end_ip", mapping: "start_ip: from\ncolumns:\n  - column: name\n    field: name\n", wantErr: "start_ip and end_ip must both be set"},
+		{name: "network and range", mapping: "network: net\nstart_ip: from\nend_ip: to\ncolumns:\n  - column: name\n    field: name\n", wantErr: "use either network or start_ip and end_ip"},
+		{name: "unknown empty mode", mapping: "empty: drop\ncolumns:\n  - column: name\n    field: name\n", wantErr: `unsupported empty "drop"`},
+		{name: "unknown column empty mode", mapping: "columns:\n  - column: name\n    field: name\n    empty: drop\n", wantErr: `columns[0]: unsupported empty "drop"`},
+		{name: "no columns", mapping: "network: net\n", wantErr: "mapping declares no columns"},
+		{name: "missing column", mapping: "columns:\n  - field: name\n", wantErr: "columns[0]: column is required"},
+		{name: "missing field", mapping: "columns:\n  - column: name\n", wantErr: "columns[0]: field is required"},
+		{name: "empty field segment", mapping: "columns:\n  - column: name\n    field: names..en\n", wantErr: `columns[0]: invalid field "names..en"`},
+		{name: "unsupported type", mapping: "columns:\n  - column: name\n    field: name\n    type: text\n", wantErr: `columns[0]: unsupported type "text"`},
+		{name: "duplicate field", mapping: "columns:\n  - column: a\n    field: name\n  - column: b\n    field: name\n", wantErr: "fields must be mapped only once"},
+		{name: "field nested in a field", mapping: "columns:\n  - column: a\n    field: country\n  - column: b\n    field: country.iso_code\n", wantErr: `field "country.iso_code" is nested in field "country"`},
+		{name: "sibling nested fields", mapping: "columns:\n  - column: a\n    field: country.iso_code\n  - column: b\n    field: country.names.en\n"},
+		{name: "integer_ips without a range", mapping: "integer_ips: true\ncolumns:\n  - column: name\n    field: name\n", wantErr: "integer_ips requires start_ip and end_ip"},
+		{name: "invalid skip network", mapping: "skip_networks: [1.0.0.0]\ncolumns:\n  - column: name\n    field: name\n", wantErr: "skip_networks"},
+		{name: "unknown key", mapping: "colums: []\n", wantErr: "colums"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := loadCSVMapping(writeTestFile(t, "mapping.yaml", tt.mapping))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapping.Empty != "omit" {
+				t.Errorf("empty = %q, want omit", mapping.Empty)
+			}
+			if mapping.StartIP == "" && mapping.Network != "network" {
+				t.Errorf("network = %q, want network", mapping.Network)
+			}
+			for _, column := range mapping.Columns {
+				if column.Type != "string" || column.Empty != "omit" {
+					t.Errorf("column %s: type %q, empty %q, want string and omit", column.Column, column.Type, column.Empty)
+				}
+			}
+		})
+	}
+}
+
+func TestCSVRecordReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		comma   rune
+		mapping string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "nested fields",
+			comma:   ',',
+			mapping: "columns:\n  - {column: country, field: country.iso_code}\n  - {column: name, field: country.names.en}\n  - {column: asn, field: asn, type: number}\n  - {column: lat, field: location.latitude, type: double}\n",
+			content: "network,country,name,asn,lat\n1.0.0.0/24,US,United States,13335,1.5\n",
+			want: []string{
+				`line 2: {"network":"1.0.0.0/24","data":{"asn":13335,"country":{"iso_code":"US","names":{"en":"United States"}},"location":{"latitude":{"$type":"double","value":"1.5"}}}}`,
+			},
+		},
+		{
+			name:    "omit, empty and null values",
+			comma:   ',',
+			mapping: "null_values: ['-']\ncolumns:\n  - {column: a, field: a}\n  - {column: b, field: b, empty: empty}\n",
+			content: "network,a,b\n1.0.0.0/24,,\n2.0.0.0/24,-,x\n",
+			want: []string{
+				`line 2: {"network":"1.0.0.0/24","data":{"b":""}}`,
+				`line 3: {"network":"2.0.0.0/24","data":{"b":"x"}}`,
+			},
+		},
+		{
+			name:    "empty cells skip the row",
+			comma:   ',',
+			mapping: "empty: skip\ncolumns:\n  - {column: a, field: a}\n",
+			content: "network,a\n1.0.0.0/24,\n2.0.0.0/24,x\n",
+			want:    []string{`line 3: {"network":"2.0.0.0/24","data":{"a":"x"}}`},
+		},
+		{
+			name:    "empty cells are errors",
+			comma:   ',',
+			mapping: "empty: error\ncolumns:\n  - {column: a, field: a}\n",
+			content: "network,a\n1.0.0.0/24,\n2.0.0.0/24,x\n",
+			want: []string{
+				"error line 2: column a is empty",
+				`line 3: {"network":"2.0.0.0/24","data":{"a":"x"}}`,
+			},
+		},
+		{
+			name:    "invalid cell",
+			comma:   ',',
+			mapping: "columns:\n  - {column: a, field: a, type: bool}\n",
+			content: "network,a\n1.0.0.0/24,maybe\n",
+			want:    []string{`error line 2: column a: "maybe" is not a bool`},
+		},
+		{
+			name:    "positional columns without a header",
+			comma:   ',',
+			mapping: "no_header: true\nnetwork: '1'\ncolumns:\n  - {column: '3', field: name}\n",
+			content: "1.0.0.0/24,ignored,one\n2.0.0.0/24\n",
+			want: []string{
+				`line 1: {"network":"1.0.0.0/24","data":{"name":"one"}}`,
+				`line 2: {"network":"2.0.0.0/24","data":{}}`,
+			},
+		},
+		{
+			name:    "row without the network column",
+			comma:   ',',
+			mapping: "no_header: true\nnetwork: '2'\ncolumns:\n  - {column: '1', field: name}\n",
+			content: "x\n",
+			want:    []string{"error line 1: row has 1 columns, network column is missing"},
+		},
+		{
+			name:    "column names without a header",
+			comma:   ',',
+			mapping: "no_header: true\ncolumns:\n  - {column: name, field: name}\n",
+			content: "1.0.0.0/24,x\n",
+			wantErr: `column "network" must be a 1-based position`,
+		},
+		{
+			name:    "column missing from the header",
+			comma:   ',',
+			mapping: "columns:\n  - {column: name, field: name}\n",
+			content: "network,nom\n",
+			wantErr: `column "name" not found in header`,
+		},
+		{
+			name:    "empty file",
+			comma:   ',',
+			mapping: "columns:\n  - {column: name, field: name}\n",
+			content: "",
+			wantErr: "file has no header row",
+		},
+		{
+			name:    "csv quoting",
+			comma:   ',',
+			mapping: "columns:\n  - {column: name, field: name}\n",
+			content: "network,name\n1.0.0.0/24,\"a, \"\"b\"\"\"\n2.0.0.0/24,\"multi\nline\"\n3.0.0.0/24,\"bad\"x\n4.0.0.0/24, ok \n",
+			want: []string{
+				`line 2: {"network":"1.0.0.0/24","data":{"name":"a, \"b\""}}`,
+				`line 3: {"network":"2.0.0.0/24","data":{"name":"multi\nline"}}`,
+				`error line 5: invalid row: extraneous or missing " in quoted-field`,
+				`line 6: {"network":"4.0.0.0/24","data":{"name":"ok"}}`,
+			},
+		},
+		{
+			name:    "tsv lines",
+			comma:   '\t',
+			mapping: "columns:\n  - {column: name, field: name}\n",
+			content: "network\tname\n1.0.0.0/24\t\"quoted\"\n\n2.0.0.0/24\ta,b\r\n3.0.0.0/24\tlast",
+			want: []string{
+				`line 2: {"network":"1.0.0.0/24","data":{"name":"\"quoted\""}}`,
+				`line 4: {"network":"2.0.0.0/24","data":{"name":"a,b"}}`,
+				`line 5: {"network":"3.0.0.0/24","data":{"name":"last"}}`,
+			},
+		},
+		{
+			name:    "integer ranges and skipped networks",
+			comma:   ',',
+			mapping: "start_ip: from\nend_ip: to\ninteger_ips: true\nskip_networks: [2.0.0.0/8]\ncolumns:\n  - {column: name, field: name}\n",
+			content: "from,to,name\n16777216,16777471,one\n33554432,33554687,two\n1,0.0.0.5,bad\n",
+			want: []string{
+				`line 2: {"start_ip":"1.0.0.0","end_ip":"1.0.0.255","data":{"name":"one"}}`,
+				`error line 4: invalid integer IP "0.0.0.5"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := loadCSVMapping(writeTestFile(t, "mapping.yaml", tt.mapping))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := newCSVRecordReader(writeTestFile(t, "input.csv", tt.content), tt.comma, mapping)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			var got []string
+			for {
+				record, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				var recordErr *ValidationError
+				if errors.As(err, &recordErr) {
+					got = append(got, fmt.Sprintf("error %s: %s", recordErr.Field, recordErr.Message))
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				encoded, err := json.Marshal(record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, fmt.Sprintf("%s: %s", reader.Location(), encoded))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log"
+	"net/netip"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/oschwald/maxminddb-golang/v2"
+	"go4.org/netipx"
+)
+
+// maxDiffDetails limits how many networks the human output lists, the JSON
+// output always has all of them.
+const maxDiffDetails = 100
+
+// DiffOutput is the JSON output of `mmdbimport diff`
+type DiffOutput struct {
+	Old       string           `json:"old"`
+	New       string           `json:"new"`
+	Metadata  []FieldChange    `json:"metadata"`
+	Added     int              `json:"added"`
+	Removed   int              `json:"removed"`
+	Changed   int              `json:"changed"`
+	Unchanged int              `json:"unchanged"`
+	Fields    []FieldDiffCount `json:"fields"`
+	Networks  []NetworkDiff    `json:"networks,omitempty"`
+	// MoreNetworks counts the networks left out of Networks by the limit
+	// of diffDatabases
+	MoreNetworks int `json:"more_networks,omitempty"`
+}
+
+// NetworkDiff is an added, removed or changed network. Old and New hold
+// the records in the form of --export.
+type NetworkDiff struct {
+	Network string        `json:"network"`
+	Change  string        `json:"change"`
+	Old     any           `json:"old,omitempty"`
+	New     any           `json:"new,omitempty"`
+	Fields  []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a field whose value differs, Old or New is missing when
+// the field was added or removed
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// FieldDiffCount counts the changed networks in which a field was added,
+// removed or changed
+type FieldDiffCount struct {
+	Field   string `json:"field"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Changed int    `json:"changed"`
+}
+
+// diffPiece is the part of a network that is left to compare, a network
+// of one database can be split by the networks of the other
+type diffPiece struct {
+	r    netipx.IPRange
+	data map[string]any
+}
+
+// diffStream reads the networks of a database in address order, IPv4
+// networks first
+type diffStream struct {
+	next    func() (maxminddb.Result, bool)
+	stop    func()
+	dser    *exportDeserializer
+	current *diffPiece
+}
+
+func newDiffStream(reader *maxminddb.Reader) *diffStream {
+	next, stop := iter.Pull(reader.Networks())
+	return &diffStream{next: next, stop: stop, dser: newExportDeserializer()}
+}
+
+// advance moves to the next network, current is nil at the end
+func (s *diffStream) advance() error {
+	s.current = nil
+	result, ok := s.next()
+	if !ok {
+		return nil
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("reading networks: %w", err)
+	}
+	data, err := s.dser.decode(result)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", result.Prefix(), err)
+	}
+	s.current = &diffPiece{r: netipx.RangeOfPrefix(result.Prefix()), data: data}
+	return nil
+}
+
+// consume drops the part of the current network up to and including end
+func (s *diffStream) consume(end netip.Addr) error {
+	if s.current.r.To() == end {
+		return s.advance()
+	}
+	s.current.r = netipx.IPRangeFrom(end.Next(), s.current.r.To())
+	return nil
+}
+
+// addrBefore orders addresses the way Networks returns them, IPv4 first
+func addrBefore(a, b netip.Addr) bool {
+	if a.Is4() != b.Is4() {
+		return a.Is4()
+	}
+	return a.Less(b)
+}
+
+// diffDatabases compares the networks of two databases. Networks are
+// compared address by address, so a network split into smaller networks
+// with the same data is not a change. At most limit networks are listed,
+// the others are only counted. A negative limit lists all of them.
+func diffDatabases(oldPath, newPath string, limit int) (*DiffOutput, error) {
+	oldReader, err := maxminddb.Open(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", oldPath, err)
+	}
+	defer oldReader.Close()
+	newReader, err := maxminddb.Open(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", newPath, err)
+	}
+	defer newReader.Close()
+
+	output := &DiffOutput{
+		Old:      oldPath,
+		New:      newPath,
+		Metadata: diffMetadata(oldReader.Metadata, newReader.Metadata),
+	}
+
+	oldStream, newStream := newDiffStream(oldReader), newDiffStream(newReader)
+	defer oldStream.stop()
+	defer newStream.stop()
+	if err := oldStream.advance(); err != nil {
+		return nil, fmt.Errorf("%s: %w", oldPath, err)
+	}
+	if err := newStream.advance(); err != nil {
+		return nil, fmt.Errorf("%s: %w", newPath, err)
+	}
+
+	counts := map[string]*FieldDiffCount{}
+	report := func(r netipx.IPRange, change string, oldData, newData map[string]any, fields []FieldChange) {
+		prefixes := r.Prefixes()
+		switch change {
+		case "added":
+			output.Added += len(prefixes)
+		case "removed":
+			output.Removed += len(prefixes)
+		case "changed":
+			output.Changed += len(prefixes)
+			for _, field := range fields {
+				count := counts[field.Field]
+				if count == nil {
+					count = &FieldDiffCount{Field: field.Field}
+					counts[field.Field] = count
+				}
+				switch {
+				case field.Old == nil:
+					count.Added += len(prefixes)
+				case field.New == nil:
+					count.Removed += len(prefixes)
+				default:
+					count.Changed += len(prefixes)
+				}
+			}
+		}
+		for _, prefix := range prefixes {
+			if limit >= 0 && len(output.Networks) >= limit {
+				output.MoreNetworks++
+				continue
+			}
+			entry := NetworkDiff{Network: prefix.String(), Change: change, Fields: fields}
+			if oldData != nil {
+				entry.Old = oldData
+			}
+			if newData != nil {
+				entry.New = newData
+			}
+			output.Networks = append(output.Networks, entry)
+		}
+	}
+
+	for oldStream.current != nil || newStream.current != nil {
+		o, n := oldStream.current, newStream.current
+		switch {
+		case n == nil || o != nil && addrBefore(o.r.To(), n.r.From()):
+			// The old network ends before the next new one starts
+			report(o.r, "removed", o.data, nil, nil)
+			err = oldStream.advance()
+		case o == nil || addrBefore(n.r.To(), o.r.From()):
+			report(n.r, "added", nil, n.data, nil)
+			err = newStream.advance()
+		case addrBefore(o.r.From(), n.r.From()):
+			// The networks overlap, the part before the new network is gone
+			end := n.r.From().Prev()
+			report(netipx.IPRangeFrom(o.r.From(), end), "removed", o.data, nil, nil)
+			err = oldStream.consume(end)
+		case addrBefore(n.r.From(), o.r.From()):
+			end := o.r.From().Prev()
+			report(netipx.IPRangeFrom(n.r.From(), end), "added", nil, n.data, nil)
+			err = newStream.consume(end)
+		default:
+			// Both start at the same address, compare up to the end of the
+			// shorter one
+			end := o.r.To()
+			if addrBefore(n.r.To(), end) {
+				end = n.r.To()
+			}
+			r := netipx.IPRangeFrom(o.r.From(), end)
+			if reflect.DeepEqual(o.data, n.data) {
+				output.Unchanged += len(r.Prefixes())
+			} else {
+				report(r, "changed", o.data, n.data, diffFields(o.data, n.data))
+			}
+			if err = oldStream.consume(end); err == nil {
+				err = newStream.consume(end)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	output.Fields = make([]FieldDiffCount, 0, len(counts))
+	for _, count := range counts {
+		output.Fields = append(output.Fields, *count)
+	}
+	sort.Slice(output.Fields, func(i, j int) bool {
+		a, b := output.Fields[i], output.Fields[j]
+		if a.Added+a.Removed+a.Changed != b.Added+b.Removed+b.Changed {
+			return a.Added+a.Removed+a.Changed > b.Added+b.Removed+b.Changed
+		}
+		return a.Field < b.Field
+	})
+	return output, nil
+}
+
+// diffFields compares two records field by field, nested maps and arrays
+// are compared by their leaf values, e.g. "subdivisions[0].iso_code"
+func diffFields(oldData, newData map[string]any) []FieldChange {
+	oldFields, newFields := map[string]any{}, map[string]any{}
+	flattenFields("", oldData, oldFields)
+	flattenFields("", newData, newFields)
+
+	var changes []FieldChange
+	for field, oldValue := range oldFields {
+		newValue, ok := newFields[field]
+		if !ok {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue})
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	for field, newValue := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// flattenFields collects the leaf values of a record by their field path.
+// Typed values ({"$type": ...}) and empty maps and arrays are leaves.
+func flattenFields(path string, value any, fields map[string]any) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 || isTypedValue(v) {
+			break
+		}
+		for key, item := range v {
+			if path == "" {
+				flattenFields(key, item, fields)
+			} else {
+				flattenFields(path+"."+key, item, fields)
+			}
+		}
+		return
+	case []any:
+		if len(v) == 0 {
+			break
+		}
+		for i, item := range v {
+			flattenFields(fmt.Sprintf("%s[%d]", path, i), item, fields)
+		}
+		return
+	}
+	fields[path] = value
+}
+
+// diffMetadata lists the metadata fields that differ
+func diffMetadata(oldMeta, newMeta maxminddb.Metadata) []FieldChange {
+	buildTime := func(epoch uint) string {
+		return time.Unix(int64(epoch), 0).UTC().Format(time.RFC3339)
+	}
+	changes := []FieldChange{}
+	for _, field := range []struct {
+		name     string
+		old, new any
+	}{
+		{"database_type", oldMeta.DatabaseType, newMeta.DatabaseType},
+		{"description", oldMeta.Description, newMeta.Description},
+		{"languages", oldMeta.Languages, newMeta.Languages},
+		{"build_epoch", buildTime(oldMeta.BuildEpoch), buildTime(newMeta.BuildEpoch)},
+		{"ip_version", oldMeta.IPVersion, newMeta.IPVersion},
+		{"record_size", oldMeta.RecordSize, newMeta.RecordSize},
+		{"node_count", oldMeta.NodeCount, newMeta.NodeCount},
+	} {
+		if !reflect.DeepEqual(field.old, field.new) {
+			changes = append(changes, FieldChange{Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+	return changes
+}
+
+// runDiffCommand implements `mmdbimport diff OLD NEW`
+func runDiffCommand(args []string) {
+	app := kingpin.New("mmdbimport diff", "Show the networks added, removed and changed between two MMDB files")
+	app.HelpFlag.Short('h')
+	app.UsageWriter(os.Stdout)
+
+	oldFile := app.Arg("old", "Old MMDB file").Required().ExistingFile()
+	newFile := app.Arg("new", "New MMDB file").Required().ExistingFile()
+	jsonOutput := app.Flag("json", "Output in JSON format").Bool()
+	summaryOnly := app.Flag("summary", "Only print the counts, not the networks").Bool()
+
+	kingpin.MustParse(app.Parse(args))
+
+	// The human output lists the first networks only, there is no need
+	// to hold the others in memory
+	limit := -1
+	if *summaryOnly {
+		limit = 0
+	} else if !*jsonOutput {
+		limit = maxDiffDetails
+	}
+	output, err := diffDatabases(*oldFile, *newFile, limit)
+	if err != nil {
+		log.Fatal(errorColor(fmt.Sprintf("Error comparing MMDB files: %v", err)))
+	}
+
+	if *jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			log.Fatal(errorColor(fmt.Sprintf("Error marshalling JSON: %v", err)))
+		}
+		fmt.Printf("%s\n", string(jsonData))
+		return
+	}
+	printDiff(output)
+}
+
+func printDiff(output *DiffOutput) {
+	fmt.Printf("%s %s -> %s\n", infoColor("Diff:"), output.Old, output.New)
+
+	fmt.Printf("\n%s\n", infoColor("Metadata:"))
+	if len(output.Metadata) == 0 {
+		fmt.Printf("  %s\n", successColor("No changes"))
+	}
+	for _, change := range output.Metadata {
+		fmt.Printf("  %s: %s -> %s\n", change.Field, formatDiffValue(change.Old), formatDiffValue(change.New))
+	}
+
+	fmt.Printf("\n%s\n", infoColor("Networks:"))
+	fmt.Printf("  Added: %s\n", successColor(formatCount(output.Added)))
+	fmt.Printf("  Removed: %s\n", errorColor(formatCount(output.Removed)))
+	fmt.Printf("  Changed: %s\n", warnColor(formatCount(output.Changed)))
+	fmt.Printf("  Unchanged: %s\n", formatCount(output.Unchanged))
+
+	if len(output.Fields) > 0 {
+		fmt.Printf("\n%s\n", infoColor("Fields:"))
+		for _, count := range output.Fields {
+			var parts []string
+			for _, part := range []struct {
+				change string
+				count  int
+			}{{"changed", count.Changed}, {"added", count.Added}, {"removed", count.Removed}} {
+				if part.count > 0 {
+					parts = append(parts, fmt.Sprintf("%s for %s networks", part.change, formatCount(part.count)))
+				}
+			}
+			fmt.Printf("  %s %s\n", count.Field, strings.Join(parts, ", "))
+		}
+	}
+
+	if len(output.Networks) == 0 {
+		return
+	}
+	fmt.Printf("\n%s\n", infoColor("Changes:"))
+	for _, network := range output.Networks {
+		switch network.Change {
+		case "added":
+			fmt.Printf("  %s %s\n", successColor("+"), network.Network)
+		case "removed":
+			fmt.Printf("  %s %s\n", errorColor("-"), network.Network)
+		case "changed":
+			fmt.Printf("  %s %s\n", warnColor("~"), network.Network)
+			for _, field := range network.Fields {
+				fmt.Printf("      %s: %s -> %s\n", field.Field, formatDiffValue(field.Old), formatDiffValue(field.New))
+			}
+		}
+	}
+	if output.MoreNetworks > 0 {
+		fmt.Printf("  ... and %d more, use --json to list all\n", output.MoreNetworks)
+	}
+}
+
+// formatDiffValue prints a value as JSON, a missing value as (none)
+func formatDiffValue(value any) string {
+	if value == nil {
+		return "(none)"
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// formatCount formats a count with thousands separators, e.g. 12,034
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestDiffDatabases(t *testing.T) {
+	a := func(v string) mmdbtype.Map { return mmdbtype.Map{"a": mmdbtype.String(v)} }
+
+	tests := []struct {
+		name      string
+		old, new  map[string]mmdbtype.Map
+		networks  []string
+		unchanged int
+	}{
+		{
+			name:      "network split with the same data",
+			old:       map[string]mmdbtype.Map{"1.0.0.0/24": a("x")},
+			new:       map[string]mmdbtype.Map{"1.0.0.0/25": a("x"), "1.0.0.128/26": a("x"), "1.0.0.192/26": a("y")},
+			networks:  []string{"changed 1.0.0.192/26"},
+			unchanged: 2,
+		},
+		{
+			name:     "changed and shrunk network",
+			old:      map[string]mmdbtype.Map{"1.0.0.0/24": a("x")},
+			new:      map[string]mmdbtype.Map{"1.0.0.0/25": a("y")},
+			networks: []string{"changed 1.0.0.0/25", "removed 1.0.0.128/25"},
+		},
+		{
+			name:      "grown network",
+			old:       map[string]mmdbtype.Map{"1.0.1.0/24": a("x")},
+			new:       map[string]mmdbtype.Map{"1.0.0.0/23": a("x")},
+			networks:  []string{"added 1.0.0.0/24"},
+			unchanged: 1,
+		},
+		{
+			name:     "added and removed networks",
+			old:      map[string]mmdbtype.Map{"1.0.0.0/24": a("x"), "2a00::/32": a("x")},
+			new:      map[string]mmdbtype.Map{"2.0.0.0/24": a("x"), "2a01::/32": a("x")},
+			networks: []string{"removed 1.0.0.0/24", "added 2.0.0.0/24", "removed 2a00::/32", "added 2a01::/32"},
+		},
+		{
+			name:     "network inside a new network with other data",
+			old:      map[string]mmdbtype.Map{"1.0.0.64/26": a("x")},
+			new:      map[string]mmdbtype.Map{"1.0.0.0/24": a("y")},
+			networks: []string{"added 1.0.0.0/26", "changed 1.0.0.64/26", "added 1.0.0.128/25"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldPath := writeTestDatabase(t, "old.mmdb", tt.old)
+			newPath := writeTestDatabase(t, "new.mmdb", tt.new)
+			output, err := diffDatabases(oldPath, newPath, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var networks []string
+			counts := map[string]int{}
+			for _, network := range output.Networks {
+				networks = append(networks, network.Change+" "+network.Network)
+				counts[network.Change]++
+			}
+			if !reflect.DeepEqual(networks, tt.networks) {
+				t.Errorf("networks = %q, want %q", networks, tt.networks)
+			}
+			if output.Added != counts["added"] || output.Removed != counts["removed"] || output.Changed != counts["changed"] {
+				t.Errorf("counts = %d added, %d removed, %d changed, want %v", output.Added, output.Removed, output.Changed, counts)
+			}
+			if output.Unchanged != tt.unchanged {
+				t.Errorf("unchanged = %d, want %d", output.Unchanged, tt.unchanged)
+			}
+		})
+	}
+}
+
+func TestDiffDatabasesLimit(t *testing.T) {
+	oldPath := writeTestDatabase(t, "old.mmdb", map[string]mmdbtype.Map{})
+	newPath := writeTestDatabase(t, "new.mmdb", map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"a": mmdbtype.String("x")},
+		"2.0.0.0/24": {"a": mmdbtype.String("x")},
+		"3.0.0.0/24": {"a": mmdbtype.String("x")},
+	})
+
+	for _, tt := range []struct {
+		limit    int
+		networks int
+		more     int
+	}{
+		{-1, 3, 0},
+		{2, 2, 1},
+		{0, 0, 3},
+	} {
+		output, err := diffDatabases(oldPath, newPath, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output.Networks) != tt.networks || output.MoreNetworks != tt.more || output.Added != 3 {
+			t.Errorf("limit %d: %d networks, %d more, %d added, want %d, %d and 3",
+				tt.limit, len(output.Networks), output.MoreNetworks, output.Added, tt.networks, tt.more)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// exportDatabase writes all networks of an MMDB file to w in the input format
+// read by --input, so the database can be edited and rebuilt. format is
+// "json" or "jsonl" (a metadata header line followed by one record per line).
+func exportDatabase(filepath string, format string, w io.Writer) error {
+	reader, err := maxminddb.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("opening MMDB file: %w", err)
+	}
+	defer reader.Close()
+
+	buildEpoch := int64(reader.Metadata.BuildEpoch)
+	ipVersion := int(reader.Metadata.IPVersion)
+	recordSize := int(reader.Metadata.RecordSize)
+	metadata := Metadata{
+		DatabaseType:   reader.Metadata.DatabaseType,
+		Description:    reader.Metadata.Description,
+		Languages:      reader.Metadata.Languages,
+		BuildTimestamp: &buildEpoch,
+		IPVersion:      &ipVersion,
+		RecordSize:     &recordSize,
+	}
+
+	out := bufio.NewWriter(w)
+	if format == "jsonl" {
+		header, err := json.Marshal(map[string]Metadata{"metadata": metadata})
+		if err != nil {
+			return fmt.Errorf("marshalling metadata: %w", err)
+		}
+		fmt.Fprintf(out, "%s\n", header)
+	} else {
+		header, err := json.MarshalIndent(metadata, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling metadata: %w", err)
+		}
+		fmt.Fprintf(out, "{\n  \"metadata\": %s,\n  \"records\": [", header)
+	}
+
+	dser := newExportDeserializer()
+	count := 0
+	for result := range reader.Networks() {
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("reading networks: %w", err)
+		}
+		data, err := dser.decode(result)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", result.Prefix(), err)
+		}
+		record, err := json.Marshal(JSONRecord{Network: result.Prefix().String(), Data: data})
+		if err != nil {
+			return fmt.Errorf("marshalling %s: %w", result.Prefix(), err)
+		}
+
+		switch {
+		case format == "jsonl":
+			fmt.Fprintf(out, "%s\n", record)
+		case count == 0:
+			fmt.Fprintf(out, "\n    %s", record)
+		default:
+			fmt.Fprintf(out, ",\n    %s", record)
+		}
+		count++
+	}
+
+	if format != "jsonl" {
+		if count > 0 {
+			fmt.Fprint(out, "\n  ")
+		}
+		fmt.Fprint(out, "]\n}\n")
+	}
+	return out.Flush()
+}
+
+// exportDeserializer decodes MMDB data into values that encoding/json writes
+// back in the input format. Numbers whose MMDB type would not be picked again
+// by the default --int-type auto and --float-type double, and values JSON has
+// no type for, are written as $type annotated values.
+type exportDeserializer struct {
+	stack []*exportContainer
+	value any
+	// cache holds decoded maps and slices by offset, records usually share
+	// most of their data. It is cleared once it holds cacheSize values, so a
+	// walk over a large database does not keep every value it decoded.
+	cache      map[uintptr]any
+	cacheSize  int
+	lastOffset uintptr
+}
+
+// exportCacheSize is the number of decoded values an exportDeserializer
+// keeps. Shared data is usually referenced from nearby records, so a small
+// cache catches most of it.
+const exportCacheSize = 4096
+
+type exportContainer struct {
+	offset uintptr
+	m      map[string]any
+	s      []any
+	key    *string
+}
+
+func newExportDeserializer() *exportDeserializer {
+	return &exportDeserializer{cache: map[uintptr]any{}, cacheSize: exportCacheSize}
+}
+
+// decode decodes the record of result, which must be a map
+func (d *exportDeserializer) decode(result maxminddb.Result) (map[string]any, error) {
+	d.stack = d.stack[:0]
+	d.value = nil
+	if err := result.Decode(d); err != nil {
+		return nil, err
+	}
+	data, ok := d.value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("record is a %T, only maps can be exported", d.value)
+	}
+	return data, nil
+}
+
+func (d *exportDeserializer) ShouldSkip(offset uintptr) (bool, error) {
+	if v, ok := d.cache[offset]; ok {
+		return true, d.add(v)
+	}
+	d.lastOffset = offset
+	return false, nil
+}
+
+func (d *exportDeserializer) StartSlice(size uint) error {
+	d.stack = append(d.stack, &exportContainer{offset: d.lastOffset, s: make([]any, 0, size)})
+	return nil
+}
+
+func (d *exportDeserializer) StartMap(size uint) error {
+	d.stack = append(d.stack, &exportContainer{offset: d.lastOffset, m: make(map[string]any, size)})
+	return nil
+}
+
+func (d *exportDeserializer) End() error {
+	if len(d.stack) == 0 {
+		return errors.New("received an End but the stack is empty")
+	}
+	c := d.stack[len(d.stack)-1]
+	d.stack = d.stack[:len(d.stack)-1]
+
+	var v any = c.s
+	if c.m != nil {
+		v = c.m
+	}
+	if len(d.cache) >= d.cacheSize {
+		clear(d.cache)
+	}
+	d.cache[c.offset] = v
+	return d.add(v)
+}
+
+func (d *exportDeserializer) String(v string) error {
+	return d.add(v)
+}
+
+func (d *exportDeserializer) Float64(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return d.add(exportTyped("double", strconv.FormatFloat(v, 'g', -1, 64)))
+	}
+	// Keep a fraction so the value is read back as a double
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if isIntegerLiteral(s) {
+		s += ".0"
+	}
+	return d.add(json.Number(s))
+}
+
+func (d *exportDeserializer) Bytes(v []byte) error {
+	return d.add(map[string]any{typeKey: "bytes", "base64": base64.StdEncoding.EncodeToString(v)})
+}
+
+func (d *exportDeserializer) Uint16(v uint16) error {
+	return d.addInteger("uint16", new(big.Int).SetUint64(uint64(v)))
+}
+
+func (d *exportDeserializer) Uint32(v uint32) error {
+	return d.addInteger("uint32", new(big.Int).SetUint64(uint64(v)))
+}
+
+func (d *exportDeserializer) Int32(v int32) error {
+	return d.addInteger("int32", big.NewInt(int64(v)))
+}
+
+func (d *exportDeserializer) Uint64(v uint64) error {
+	return d.addInteger("uint64", new(big.Int).SetUint64(v))
+}
+
+func (d *exportDeserializer) Uint128(v *big.Int) error {
+	// Always annotated and written as a string, most JSON readers cannot
+	// hold 128 bit numbers.
+	return d.add(exportTyped("uint128", v.String()))
+}
+
+func (d *exportDeserializer) Bool(v bool) error {
+	return d.add(v)
+}
+
+func (d *exportDeserializer) Float32(v float32) error {
+	if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+		return d.add(exportTyped("float", strconv.FormatFloat(float64(v), 'g', -1, 32)))
+	}
+	return d.add(exportTyped("float", json.Number(strconv.FormatFloat(float64(v), 'g', -1, 32))))
+}
+
+// addInteger adds a plain JSON number when --int-type auto would pick
+// mmdbType for it again, and an annotated value otherwise.
+func (d *exportDeserializer) addInteger(mmdbType string, v *big.Int) error {
+	if smallestIntegerType(v) == mmdbType {
+		return d.add(json.Number(v.String()))
+	}
+	return d.add(exportTyped(mmdbType, json.Number(v.String())))
+}
+
+func (d *exportDeserializer) add(v any) error {
+	if len(d.stack) == 0 {
+		d.value = v
+		return nil
+	}
+
+	c := d.stack[len(d.stack)-1]
+	switch {
+	case c.m == nil:
+		c.s = append(c.s, v)
+	case c.key == nil:
+		key, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("map key is a %T, not a string", v)
+		}
+		if key == typeKey {
+			return fmt.Errorf("map key %q cannot be exported, it marks typed values", typeKey)
+		}
+		c.key = &key
+	default:
+		c.m[*c.key] = v
+		c.key = nil
+	}
+	return nil
+}
+
+func exportTyped(mmdbType string, value any) map[string]any {
+	return map[string]any{typeKey: mmdbType, "value": value}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	uint128, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+	records := map[string]mmdbtype.Map{
+		"1.0.0.0/24": {
+			"name":    mmdbtype.String("one"),
+			"port":    mmdbtype.Uint16(443),
+			"asn":     mmdbtype.Uint32(13335),
+			"big":     mmdbtype.Uint64(1 << 40),
+			"huge":    (*mmdbtype.Uint128)(uint128),
+			"offset":  mmdbtype.Int32(-3),
+			"ratio":   mmdbtype.Float64(0.5),
+			"weight":  mmdbtype.Float32(1.25),
+			"active":  mmdbtype.Bool(true),
+			"raw":     mmdbtype.Bytes{0xde, 0xad},
+			"tags":    mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.Uint16(2)},
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+		},
+		"2.0.0.0/16": {
+			"name":  mmdbtype.String("two"),
+			"small": mmdbtype.Uint32(7),
+		},
+	}
+
+	dbPath := writeTestTree(t, "test.mmdb", newTestTree(t, mmdbwriter.Options{
+		Description: map[string]string{"en": "export test"},
+		Languages:   []string{"en"},
+		IPVersion:   6,
+		RecordSize:  24,
+		BuildEpoch:  1700000000,
+	}, records))
+	dir := filepath.Dir(dbPath)
+
+	for _, format := range []string{"json", "jsonl"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := exportDatabase(dbPath, format, &buf); err != nil {
+				t.Fatal(err)
+			}
+			exportPath := filepath.Join(dir, "export."+format)
+			if err := os.WriteFile(exportPath, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := openRecordReader([]string{exportPath}, InputOptions{Format: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			metadata := reader.Metadata()
+			if metadata.DatabaseType != "Test" || metadata.Description["en"] != "export test" {
+				t.Errorf("metadata = %+v", metadata)
+			}
+			if metadata.BuildTimestamp == nil || *metadata.BuildTimestamp != 1700000000 {
+				t.Errorf("build_epoch = %v, want 1700000000", metadata.BuildTimestamp)
+			}
+			if metadata.IPVersion == nil || *metadata.IPVersion != 6 {
+				t.Errorf("ip_version = %v, want 6", metadata.IPVersion)
+			}
+			if metadata.RecordSize == nil || *metadata.RecordSize != 24 {
+				t.Errorf("record_size = %v, want 24", metadata.RecordSize)
+			}
+
+			seen := 0
+			for {
+				record, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, ok := records[record.Network]
+				if !ok {
+					t.Errorf("unexpected network %s", record.Network)
+					continue
+				}
+				got, err := convertToMMDBType(record.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got.Equal(want) {
+					t.Errorf("%s: got %#v, want %#v", record.Network, got, want)
+				}
+				seen++
+			}
+			if seen != len(records) {
+				t.Errorf("read %d records, want %d", seen, len(records))
+			}
+		})
+	}
+}
+
+func TestExportDeserializerCacheBounded(t *testing.T) {
+	shared := mmdbtype.Map{"iso_code": mmdbtype.String("US")}
+	networks := map[string]mmdbtype.Map{}
+	for i := 0; i < 8; i++ {
+		networks[fmt.Sprintf("%d.0.0.0/8", i+1)] = mmdbtype.Map{"id": mmdbtype.Uint32(i), "country": shared}
+	}
+	reader, err := maxminddb.Open(writeTestDatabase(t, "test.mmdb", networks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	dser := newExportDeserializer()
+	dser.cacheSize = 3
+	for result := range reader.Networks() {
+		data, err := dser.decode(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := convertToMMDBType(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := networks[result.Prefix().String()]; !got.Equal(want) {
+			t.Errorf("%s: got %#v, want %#v", result.Prefix(), got, want)
+		}
+		if len(dser.cache) > dser.cacheSize {
+			t.Fatalf("cache holds %d values, want at most %d", len(dser.cache), dser.cacheSize)
+		}
+	}
+}
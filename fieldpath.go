@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// FieldPath is a parsed --field selector such as "country.iso_code" or
+// "subdivisions[0].names.en".
+type FieldPath struct {
+	Name     string
+	Elements []any
+}
+
+// parseFieldPath turns a dotted path into the keys (strings) and indices
+// (ints) expected by maxminddb's DecodePath. Indices can be written as
+// "subdivisions[0]" or "subdivisions.0", negative indices count from the end.
+func parseFieldPath(path string) (FieldPath, error) {
+	field := FieldPath{Name: path}
+	if path == "" {
+		return field, fmt.Errorf("field path cannot be empty")
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var indices []string
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+			rest := part[i:]
+			for rest != "" {
+				end := strings.IndexByte(rest, ']')
+				if rest[0] != '[' || end < 0 {
+					return field, fmt.Errorf("invalid field path %q: malformed index in %q", path, part)
+				}
+				indices = append(indices, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+
+		if strings.IndexByte(key, ']') >= 0 {
+			return field, fmt.Errorf("invalid field path %q: malformed index in %q", path, part)
+		}
+		if key == "" && len(indices) == 0 {
+			return field, fmt.Errorf("invalid field path %q: empty segment", path)
+		}
+		if key != "" {
+			if index, err := strconv.Atoi(key); err == nil {
+				field.Elements = append(field.Elements, index)
+			} else {
+				field.Elements = append(field.Elements, key)
+			}
+		}
+		for _, index := range indices {
+			i, err := strconv.Atoi(index)
+			if err != nil {
+				return field, fmt.Errorf("invalid field path %q: index %q is not a number", path, index)
+			}
+			field.Elements = append(field.Elements, i)
+		}
+	}
+
+	return field, nil
+}
+
+func parseFieldPaths(paths []string) ([]FieldPath, error) {
+	fields := make([]FieldPath, 0, len(paths))
+	for _, path := range paths {
+		field, err := parseFieldPath(path)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// decodeFields decodes only the selected fields of a record. Fields that are
+// missing from the record, or do not have the shape the path expects, are
+// nil.
+func decodeFields(result maxminddb.Result, fields []FieldPath) map[string]interface{} {
+	values := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		var value interface{}
+		if err := result.DecodePath(&value, field.Elements...); err != nil {
+			value = nil
+		}
+		values[field.Name] = value
+	}
+	return values
+}
+
+// formatFieldValues prints the selected values tab separated in the order of
+// fields. Scalars are printed as is, maps and arrays as compact JSON and
+// missing values as empty strings.
+func formatFieldValues(values map[string]interface{}, fields []FieldPath) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = formatFieldValue(values[field.Name])
+	}
+	return strings.Join(parts, "\t")
+}
+
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}, []byte:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []any
+		wantErr string
+	}{
+		{path: "country", want: []any{"country"}},
+		{path: "country.iso_code", want: []any{"country", "iso_code"}},
+		{path: "subdivisions[0]", want: []any{"subdivisions", 0}},
+		{path: "subdivisions.0", want: []any{"subdivisions", 0}},
+		{path: "subdivisions[-1].names.en", want: []any{"subdivisions", -1, "names", "en"}},
+		{path: "a[0][1]", want: []any{"a", 0, 1}},
+		{path: "a[0].b[2][3].c", want: []any{"a", 0, "b", 2, 3, "c"}},
+		{path: "[0].a", want: []any{0, "a"}},
+		{path: "a.[1]", want: []any{"a", 1}},
+		{path: "", wantErr: "field path cannot be empty"},
+		{path: "a..b", wantErr: "empty segment"},
+		{path: "a.", wantErr: "empty segment"},
+		{path: ".a", wantErr: "empty segment"},
+		{path: "a[", wantErr: `malformed index in "a["`},
+		{path: "a[0", wantErr: `malformed index in "a[0"`},
+		{path: "a[0]b", wantErr: `malformed index in "a[0]b"`},
+		{path: "a]", wantErr: `malformed index in "a]"`},
+		{path: "a[0]]", wantErr: `malformed index in "a[0]]"`},
+		{path: "a[x]", wantErr: `index "x" is not a number`},
+		{path: "a[]", wantErr: `index "" is not a number`},
+		{path: "a[1.5]", wantErr: `malformed index in "a[1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseFieldPath(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.path || !reflect.DeepEqual(got.Elements, tt.want) {
+				t.Errorf("got %+v, want elements %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatFieldValues(t *testing.T) {
+	fields, err := parseFieldPaths([]string{"a", "b", "c", "d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]any{
+		"a": "text",
+		"b": uint64(7),
+		"c": map[string]any{"x": true},
+		"d": []any{"y", 1},
+	}
+	if got, want := formatFieldValues(values, fields), "text\t7\t{\"x\":true}\t[\"y\",1]\t"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
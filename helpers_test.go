@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// testInputJSON is a valid JSON input with complete metadata and one record
+const testInputJSON = `{"metadata":{"database_type":"Test","description":{"en":"test"},"build_epoch":1},"records":[{"network":"1.0.0.0/24","data":{"a":1}}]}`
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestInput writes testInputJSON to input.json
+func writeTestInput(t *testing.T) string {
+	t.Helper()
+	return writeTestFile(t, "input.json", testInputJSON)
+}
+
+// newTestTree creates a tree with opts, database type "Test" unless one is
+// given, and inserts networks into it
+func newTestTree(t *testing.T, opts mmdbwriter.Options, networks map[string]mmdbtype.Map) *mmdbwriter.Tree {
+	t.Helper()
+	if opts.DatabaseType == "" {
+		opts.DatabaseType = "Test"
+	}
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for network, data := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(ipNet, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+// writeTestTree writes tree to a temporary directory and returns the path
+func writeTestTree(t *testing.T, name string, tree *mmdbwriter.Tree) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := writeDatabase(tree, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestDatabase writes an IPv6 database with record size 24 holding
+// networks
+func writeTestDatabase(t *testing.T, name string, networks map[string]mmdbtype.Map) string {
+	t.Helper()
+	return writeTestTree(t, name, newTestTree(t, mmdbwriter.Options{IPVersion: 6, RecordSize: 24}, networks))
+}
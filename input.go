@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// InputOptions describes how an input file should be read.
+type InputOptions struct {
+	// Format is the input format, "json", "jsonl", "csv", "tsv",
+	// "maxmind-csv" (a directory of MaxMind CSV files), "rir" (an RIR
+	// delegated statistics file), "bgp" (a prefix to origin AS table) or
+	// "aws", "gcp", "azure" and "oracle" (published cloud range files) or
+	// "list" (a plain list of networks).
+	Format string
+	// Mapping describes the columns of csv and tsv input.
+	Mapping *CSVMapping
+	// MappingFile and SchemaFile are the files Mapping and Schema were
+	// loaded from.
+	MappingFile string
+	SchemaFile  string
+	// RIRFiles and ASNamesFile add the registry, country and organization
+	// of AS numbers to rir and bgp input.
+	RIRFiles    []string
+	ASNamesFile string
+	// ListData is the data of every network of list input.
+	ListData map[string]any
+	// MetadataFile optionally points to a JSON file holding the metadata
+	// object, overriding any metadata found in the input itself.
+	MetadataFile string
+	// Schema optionally declares the types and shape of record data.
+	Schema *Schema
+	// PrimaryFile is the input whose metadata is used when there are
+	// several inputs, otherwise their metadata is merged.
+	PrimaryFile string
+	// Metadata optionally replaces the metadata of the input, like
+	// MetadataFile.
+	Metadata *Metadata
+	// BaseMetadata is the metadata of the --base database. Metadata fields
+	// the input does not set are taken from it.
+	BaseMetadata *Metadata
+	// StdinSpool is the temporary file holding stdin, it is named "-" in
+	// messages.
+	StdinSpool string
+}
+
+// inputName names an input in messages and output
+func (opts InputOptions) inputName(path string) string {
+	if opts.StdinSpool != "" && path == opts.StdinSpool {
+		return "-"
+	}
+	return path
+}
+
+// inputNames names all inputs, see inputName
+func (opts InputOptions) inputNames(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = opts.inputName(path)
+	}
+	return names
+}
+
+// inputFormats are the formats openRecordReader reads
+var inputFormats = []string{"json", "jsonl", "csv", "tsv", "maxmind-csv", "rir", "bgp", "aws", "gcp", "azure", "oracle", "list"}
+
+// RecordReader yields the records of an input one at a time, so callers can
+// validate and insert them without holding the whole input in memory.
+type RecordReader interface {
+	// Metadata returns the metadata of the input.
+	Metadata() Metadata
+	// Next returns the next record, or io.EOF once the input is exhausted.
+	// A *ValidationError is returned for a record that could not be decoded;
+	// the reader stays usable and Next can be called again.
+	Next() (JSONRecord, error)
+	// Location describes where the last record returned by Next came from,
+	// e.g. "records[3]" or "line 4".
+	Location() string
+	Close() error
+}
+
+// openRecordReader opens the inputs as one reader, see multiRecordReader for
+// more than one input. The metadata overrides of opts are applied to it.
+func openRecordReader(paths []string, opts InputOptions) (RecordReader, error) {
+	var reader RecordReader
+	var err error
+	if len(paths) == 1 {
+		reader, err = openFormatReader(paths[0], opts)
+	} else {
+		reader, err = newMultiRecordReader(paths, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MetadataFile != "" {
+		metadataInput, err := readJSONFile(opts.MetadataFile)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("reading metadata file: %w", err)
+		}
+		reader = &metadataOverride{RecordReader: reader, metadata: metadataInput.Metadata}
+	}
+	if opts.Metadata != nil {
+		reader = &metadataOverride{RecordReader: reader, metadata: *opts.Metadata}
+	}
+
+	if opts.BaseMetadata != nil {
+		reader = &metadataOverride{RecordReader: reader, metadata: patchMetadata(*opts.BaseMetadata, reader.Metadata())}
+	}
+
+	return reader, nil
+}
+
+// openFormatReader opens a single input in the format of opts
+func openFormatReader(filepath string, opts InputOptions) (RecordReader, error) {
+	var reader RecordReader
+	var err error
+
+	switch opts.Format {
+	case "", "json":
+		reader, err = newJSONRecordReader(filepath)
+	case "jsonl":
+		reader, err = newJSONLRecordReader(filepath)
+	case "csv":
+		reader, err = newCSVRecordReader(filepath, ',', opts.Mapping)
+	case "tsv":
+		reader, err = newCSVRecordReader(filepath, '\t', opts.Mapping)
+	case "maxmind-csv":
+		reader, err = newMaxMindCSVReader(filepath)
+	case "rir", "bgp":
+		var enrichment *asnEnrichment
+		enrichment, err = loadASNEnrichment(opts.RIRFiles, opts.ASNamesFile)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Format == "rir" {
+			reader, err = newRIRRecordReader(filepath, enrichment)
+		} else {
+			reader, err = newBGPRecordReader(filepath, enrichment)
+		}
+	case "aws", "gcp", "azure", "oracle":
+		reader, err = newCloudRecordReader(filepath, opts.Format)
+	case "list":
+		reader, err = newListRecordReader(filepath, opts.ListData)
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", opts.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// metadataOverride replaces the metadata of the wrapped reader.
+type metadataOverride struct {
+	RecordReader
+	metadata Metadata
+}
+
+func (m *metadataOverride) Metadata() Metadata {
+	return m.metadata
+}
+
+// jsonRecordReader serves the records of a regular JSON input, which has to
+// be read into memory as a whole.
+type jsonRecordReader struct {
+	input InputData
+	index int
+}
+
+func newJSONRecordReader(filepath string) (*jsonRecordReader, error) {
+	input, err := readJSONFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonRecordReader{input: input, index: -1}, nil
+}
+
+func (r *jsonRecordReader) Metadata() Metadata {
+	return r.input.Metadata
+}
+
+func (r *jsonRecordReader) Next() (JSONRecord, error) {
+	if r.index+1 >= len(r.input.Records) {
+		return JSONRecord{}, io.EOF
+	}
+	r.index++
+	return r.input.Records[r.index], nil
+}
+
+func (r *jsonRecordReader) Location() string {
+	return fmt.Sprintf("records[%d]", r.index)
+}
+
+func (r *jsonRecordReader) Close() error {
+	return nil
+}
+
+// jsonlRecordReader streams JSON Lines input, one JSONRecord per line. The
+// first non-empty line may instead hold a {"metadata": {...}} header.
+type jsonlRecordReader struct {
+	file     io.ReadCloser
+	reader   *bufio.Reader
+	metadata Metadata
+	line     int
+	pending  []byte
+}
+
+func newJSONLRecordReader(filepath string) (*jsonlRecordReader, error) {
+	f, err := openInput(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+
+	r := &jsonlRecordReader{
+		file:   f,
+		reader: bufio.NewReaderSize(f, 1<<20),
+	}
+
+	// Peek at the first line to pick up an optional metadata header
+	line, err := r.nextLine()
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	if line != nil {
+		var header map[string]json.RawMessage
+		if json.Unmarshal(line, &header) == nil && header["metadata"] != nil && header["network"] == nil && header["data"] == nil {
+			if err := json.Unmarshal(header["metadata"], &r.metadata); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("parsing metadata on line %d: %w", r.line, err)
+			}
+		} else {
+			r.pending = line
+		}
+	}
+
+	return r, nil
+}
+
+// nextLine returns the next non-empty line, or io.EOF at the end of input.
+func (r *jsonlRecordReader) nextLine() ([]byte, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 || err == nil {
+			r.line++
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *jsonlRecordReader) Metadata() Metadata {
+	return r.metadata
+}
+
+func (r *jsonlRecordReader) Next() (JSONRecord, error) {
+	line := r.pending
+	r.pending = nil
+	if line == nil {
+		var err error
+		line, err = r.nextLine()
+		if err == io.EOF {
+			return JSONRecord{}, io.EOF
+		}
+		if err != nil {
+			return JSONRecord{}, fmt.Errorf("reading line %d: %w", r.line, err)
+		}
+	}
+
+	var record JSONRecord
+	if err := unmarshalJSON(line, &record); err != nil {
+		return JSONRecord{}, &ValidationError{
+			Field:   r.Location(),
+			Message: fmt.Sprintf("invalid JSON: %v", err),
+		}
+	}
+	return record, nil
+}
+
+func (r *jsonlRecordReader) Location() string {
+	return fmt.Sprintf("line %d", r.line)
+}
+
+func (r *jsonlRecordReader) Close() error {
+	return r.file.Close()
+}
+
+// unmarshalJSON decodes JSON keeping numbers as json.Number, so integers are
+// not turned into float64 before their MMDB type is chosen.
+func unmarshalJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after top-level value")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestJSONLRecordReaderHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		databaseType string
+		networks     []string
+		wantErr      bool
+	}{
+		{
+			name:         "metadata header",
+			input:        `{"metadata":{"database_type":"Test","description":{"en":"test"}}}` + "\n" + `{"network":"1.0.0.0/24","data":{"a":1}}` + "\n",
+			databaseType: "Test",
+			networks:     []string{"1.0.0.0/24"},
+		},
+		{
+			name:     "no header",
+			input:    `{"network":"1.0.0.0/24","data":{"a":1}}` + "\n" + `{"network":"2.0.0.0/24","data":{"a":2}}`,
+			networks: []string{"1.0.0.0/24", "2.0.0.0/24"},
+		},
+		{
+			name:         "blank lines before the header",
+			input:        "\n  \n" + `{"metadata":{"database_type":"Test","description":{"en":"test"}}}` + "\n\n" + `{"network":"1.0.0.0/24","data":{"a":1}}`,
+			databaseType: "Test",
+			networks:     []string{"1.0.0.0/24"},
+		},
+		{
+			name:     "record with a metadata key is a record",
+			input:    `{"metadata":{"database_type":"Test"},"network":"1.0.0.0/24","data":{"a":1}}`,
+			networks: []string{"1.0.0.0/24"},
+		},
+		{
+			name:     "empty input",
+			input:    "",
+			networks: nil,
+		},
+		{
+			name:    "unknown metadata field",
+			input:   `{"metadata":{"database_type":"Test","custom":1}}` + "\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "input.jsonl", tt.input)
+			reader, err := newJSONLRecordReader(path)
+			if tt.wantErr {
+				if err == nil {
+					reader.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			if got := reader.Metadata().DatabaseType; got != tt.databaseType {
+				t.Errorf("database_type = %q, want %q", got, tt.databaseType)
+			}
+			var networks []string
+			for {
+				record, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				networks = append(networks, record.Network)
+			}
+			if len(networks) != len(tt.networks) {
+				t.Fatalf("networks = %v, want %v", networks, tt.networks)
+			}
+			for i := range networks {
+				if networks[i] != tt.networks[i] {
+					t.Errorf("networks = %v, want %v", networks, tt.networks)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONLRecordReaderLocation(t *testing.T) {
+	path := writeTestFile(t, "input.jsonl", `{"metadata":{"database_type":"Test"}}`+"\n\n"+`{"network":"1.0.0.0/24","data":{"a":1}}`+"\n"+`not json`+"\n")
+	reader, err := newJSONLRecordReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.Location(); got != "line 3" {
+		t.Errorf("location = %q, want line 3", got)
+	}
+	_, err = reader.Next()
+	recordErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if recordErr.Field != "line 4" {
+		t.Errorf("field = %q, want line 4", recordErr.Field)
+	}
+}
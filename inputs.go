@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inputPathsValue is a repeatable kingpin flag value collecting input
+// paths, "-" for stdin, globs or existing files and directories. Globs and
+// directories are expanded by expandInputPaths once the format is known.
+type inputPathsValue []string
+
+func (v *inputPathsValue) Set(value string) error {
+	// kingpin passes a lone "-" argument on as an empty value
+	if value == "" {
+		value = "-"
+	}
+	if value != "-" && !isGlob(value) {
+		if _, err := os.Stat(value); os.IsNotExist(err) {
+			return fmt.Errorf("path '%s' does not exist", value)
+		} else if err != nil {
+			return err
+		}
+	}
+	*v = append(*v, value)
+	return nil
+}
+
+func (v *inputPathsValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *inputPathsValue) IsCumulative() bool {
+	return true
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// expandInputPaths turns the -i and -c arguments into the list of input
+// files, in the order their records are applied: arguments in command line
+// order, the matches of a glob and the files of a directory sorted by name.
+// maxmind-csv reads whole directories, which are kept as they are.
+func expandInputPaths(args []string, format string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if isGlob(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no input files match %q", arg)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				expanded, err := expandInputPath(match, format)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, expanded...)
+			}
+			continue
+		}
+
+		expanded, err := expandInputPath(arg, format)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, expanded...)
+	}
+
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[filepath.Clean(path)] {
+			return nil, fmt.Errorf("input %s is given more than once", path)
+		}
+		seen[filepath.Clean(path)] = true
+	}
+	return paths, nil
+}
+
+// expandInputPath lists the files of a directory, skipping hidden files and
+// subdirectories
+func expandInputPath(path string, format string) ([]string, error) {
+	if path == "-" || format == "maxmind-csv" {
+		return []string{path}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading input directory: %w", err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		paths = append(paths, filepath.Join(path, entry.Name()))
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("input directory %s has no files", path)
+	}
+	return paths, nil
+}
+
+// multiRecordReader reads the records of several inputs one after the other.
+// Locations are prefixed with the file they come from.
+type multiRecordReader struct {
+	paths    []string
+	names    []string
+	opts     InputOptions
+	metadata Metadata
+	current  RecordReader
+	index    int
+}
+
+func newMultiRecordReader(paths []string, opts InputOptions) (*multiRecordReader, error) {
+	// Only one input is open at a time, the metadata of all of them is
+	// collected up front
+	names := opts.inputNames(paths)
+	metadata := make([]Metadata, len(paths))
+	for i, path := range paths {
+		var err error
+		if metadata[i], err = readInputMetadata(path, opts); err != nil {
+			return nil, fmt.Errorf("%s: %w", names[i], err)
+		}
+	}
+
+	merged, err := mergeInputMetadata(names, metadata, opts.PrimaryFile)
+	if err != nil {
+		return nil, err
+	}
+	return &multiRecordReader{paths: paths, names: names, opts: opts, metadata: merged, index: -1}, nil
+}
+
+// readInputMetadata returns the metadata of one input without reading its
+// records. jsonl, csv and tsv readers only read the header on open, JSON
+// input is scanned for its metadata key and the other formats have metadata
+// that does not depend on the file content.
+func readInputMetadata(path string, opts InputOptions) (Metadata, error) {
+	switch opts.Format {
+	case "", "json":
+		return readJSONMetadata(path)
+	case "maxmind-csv":
+		return readMaxMindMetadata(path)
+	case "rir":
+		return (&rirRecordReader{}).Metadata(), nil
+	case "bgp":
+		return (&bgpRecordReader{}).Metadata(), nil
+	case "aws", "gcp", "azure", "oracle":
+		return (&cloudRecordReader{provider: opts.Format}).Metadata(), nil
+	}
+
+	reader, err := openFormatReader(path, opts)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer reader.Close()
+	return reader.Metadata(), nil
+}
+
+// readJSONMetadata reads the metadata object of a JSON input, skipping over
+// other keys without keeping their values. A legacy input holding just an
+// array of records has no metadata.
+func readJSONMetadata(path string) (Metadata, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	token, err := dec.Token()
+	if err != nil {
+		return Metadata{}, fmt.Errorf("parsing JSON: %w", err)
+	}
+	if token != json.Delim('{') {
+		return Metadata{}, nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return Metadata{}, fmt.Errorf("parsing JSON: %w", err)
+		}
+		if key == "metadata" {
+			var metadata Metadata
+			if err := dec.Decode(&metadata); err != nil {
+				return Metadata{}, fmt.Errorf("parsing JSON: %w", err)
+			}
+			return metadata, nil
+		}
+		if err := skipJSONValue(dec); err != nil {
+			return Metadata{}, fmt.Errorf("parsing JSON: %w", err)
+		}
+	}
+	return Metadata{}, nil
+}
+
+// skipJSONValue reads past the next value of dec token by token
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// mergeInputMetadata picks the metadata of the primary input, or merges the
+// metadata of all inputs. Inputs may leave fields out, but fields set by
+// several inputs have to agree, including ip_version and record_size.
+// Languages are combined and the newest build_epoch is used.
+func mergeInputMetadata(paths []string, metadata []Metadata, primary string) (Metadata, error) {
+	if primary != "" {
+		for i, path := range paths {
+			if filepath.Clean(path) == filepath.Clean(primary) {
+				return metadata[i], nil
+			}
+		}
+		return Metadata{}, fmt.Errorf("--primary %s is not one of the input files", primary)
+	}
+
+	merged := Metadata{}
+	typeFrom := ""
+	descriptionFrom := map[string]string{}
+	fieldFrom := map[string]string{}
+	for i, m := range metadata {
+		if m.DatabaseType != "" {
+			if merged.DatabaseType != "" && merged.DatabaseType != m.DatabaseType {
+				return Metadata{}, fmt.Errorf("metadata conflict: database_type is %q in %s but %q in %s, use --primary to take the metadata of one input",
+					merged.DatabaseType, typeFrom, m.DatabaseType, paths[i])
+			}
+			merged.DatabaseType, typeFrom = m.DatabaseType, paths[i]
+		}
+
+		for lang, description := range m.Description {
+			if existing, ok := merged.Description[lang]; ok && existing != description {
+				return Metadata{}, fmt.Errorf("metadata conflict: description.%s is %q in %s but %q in %s, use --primary to take the metadata of one input",
+					lang, existing, descriptionFrom[lang], description, paths[i])
+			}
+			if merged.Description == nil {
+				merged.Description = map[string]string{}
+			}
+			merged.Description[lang], descriptionFrom[lang] = description, paths[i]
+		}
+
+		for _, field := range []struct {
+			name          string
+			merged, value **int
+		}{
+			{"ip_version", &merged.IPVersion, &m.IPVersion},
+			{"record_size", &merged.RecordSize, &m.RecordSize},
+			{"binary_format_major_version", &merged.BinaryFormatMajorVersion, &m.BinaryFormatMajorVersion},
+			{"binary_format_minor_version", &merged.BinaryFormatMinorVersion, &m.BinaryFormatMinorVersion},
+		} {
+			if *field.value == nil {
+				continue
+			}
+			if *field.merged != nil && **field.merged != **field.value {
+				return Metadata{}, fmt.Errorf("metadata conflict: %s is %d in %s but %d in %s, use --primary to take the metadata of one input",
+					field.name, **field.merged, fieldFrom[field.name], **field.value, paths[i])
+			}
+			*field.merged, fieldFrom[field.name] = *field.value, paths[i]
+		}
+
+		merged.Languages = mergeLanguages(merged.Languages, m.Languages)
+		if m.BuildTimestamp != nil && (merged.BuildTimestamp == nil || *m.BuildTimestamp > *merged.BuildTimestamp) {
+			merged.BuildTimestamp = m.BuildTimestamp
+		}
+	}
+	return merged, nil
+}
+
+func mergeLanguages(languages, more []string) []string {
+	for _, lang := range more {
+		languages = appendUnique(languages, lang)
+	}
+	return languages
+}
+
+func (r *multiRecordReader) Metadata() Metadata {
+	return r.metadata
+}
+
+func (r *multiRecordReader) Next() (JSONRecord, error) {
+	for {
+		if r.current != nil {
+			record, err := r.current.Next()
+			if err != io.EOF {
+				var recordErr *ValidationError
+				if errors.As(err, &recordErr) {
+					err = &ValidationError{
+						Field:   fmt.Sprintf("%s: %s", r.names[r.index], recordErr.Field),
+						Message: recordErr.Message,
+					}
+				} else if err != nil {
+					err = fmt.Errorf("%s: %w", r.names[r.index], err)
+				}
+				return record, err
+			}
+			r.current.Close()
+			r.current = nil
+		}
+
+		if r.index+1 >= len(r.paths) {
+			return JSONRecord{}, io.EOF
+		}
+		r.index++
+		reader, err := openFormatReader(r.paths[r.index], r.opts)
+		if err != nil {
+			return JSONRecord{}, fmt.Errorf("%s: %w", r.names[r.index], err)
+		}
+		r.current = reader
+	}
+}
+
+func (r *multiRecordReader) Location() string {
+	if r.current == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", r.names[r.index], r.current.Location())
+}
+
+func (r *multiRecordReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
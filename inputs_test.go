@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestMergeInputMetadata(t *testing.T) {
+	paths := []string{"a.json", "b.json"}
+
+	tests := []struct {
+		name     string
+		metadata []Metadata
+		primary  string
+		want     Metadata
+		wantErr  string
+	}{
+		{
+			name: "fields left out by one input",
+			metadata: []Metadata{
+				{DatabaseType: "Test", Description: map[string]string{"en": "test"}, Languages: []string{"en"}},
+				{Languages: []string{"de", "en"}, RecordSize: intPtr(28)},
+			},
+			want: Metadata{
+				DatabaseType: "Test",
+				Description:  map[string]string{"en": "test"},
+				Languages:    []string{"en", "de"},
+				RecordSize:   intPtr(28),
+			},
+		},
+		{
+			name: "newest build_epoch",
+			metadata: []Metadata{
+				{DatabaseType: "Test", BuildTimestamp: int64Ptr(200)},
+				{DatabaseType: "Test", BuildTimestamp: int64Ptr(100)},
+			},
+			want: Metadata{DatabaseType: "Test", BuildTimestamp: int64Ptr(200)},
+		},
+		{
+			name: "descriptions in different languages",
+			metadata: []Metadata{
+				{Description: map[string]string{"en": "test"}},
+				{Description: map[string]string{"de": "Test"}},
+			},
+			want: Metadata{Description: map[string]string{"en": "test", "de": "Test"}},
+		},
+		{
+			name:     "database_type conflict",
+			metadata: []Metadata{{DatabaseType: "A"}, {DatabaseType: "B"}},
+			wantErr:  `database_type is "A" in a.json but "B" in b.json`,
+		},
+		{
+			name:     "description conflict",
+			metadata: []Metadata{{Description: map[string]string{"en": "a"}}, {Description: map[string]string{"en": "b"}}},
+			wantErr:  `description.en is "a" in a.json but "b" in b.json`,
+		},
+		{
+			name:     "ip_version conflict",
+			metadata: []Metadata{{IPVersion: intPtr(4)}, {IPVersion: intPtr(6)}},
+			wantErr:  "ip_version is 4 in a.json but 6 in b.json",
+		},
+		{
+			name:     "record_size conflict",
+			metadata: []Metadata{{RecordSize: intPtr(24)}, {RecordSize: intPtr(32)}},
+			wantErr:  "record_size is 24 in a.json but 32 in b.json",
+		},
+		{
+			name:     "primary input",
+			metadata: []Metadata{{DatabaseType: "A"}, {DatabaseType: "B"}},
+			primary:  "./b.json",
+			want:     Metadata{DatabaseType: "B"},
+		},
+		{
+			name:     "unknown primary input",
+			metadata: []Metadata{{DatabaseType: "A"}, {DatabaseType: "B"}},
+			primary:  "c.json",
+			wantErr:  "--primary c.json is not one of the input files",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeInputMetadata(paths, tt.metadata, tt.primary)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiRecordReaderNamesStdin(t *testing.T) {
+	first := writeTestFile(t, "first.jsonl", `{"network":"1.0.0.0/24","data":{"a":1}}`+"\n")
+	spool := writeTestFile(t, "mmdbimport-stdin-1", `{"network":"2.0.0.0/24","data":{"a":2}}`+"\n"+`not json`+"\n")
+
+	reader, err := openRecordReader([]string{first, spool}, InputOptions{Format: "jsonl", StdinSpool: spool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var locations []string
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *ValidationError
+		if errors.As(err, &recordErr) {
+			locations = append(locations, recordErr.Field)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		locations = append(locations, reader.Location())
+	}
+
+	want := []string{first + ": line 1", "-: line 1", "-: line 2"}
+	if !reflect.DeepEqual(locations, want) {
+		t.Errorf("locations = %q, want %q", locations, want)
+	}
+}
+
+func TestReadInputMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		file    string
+		content string
+		want    Metadata
+		wantErr string
+	}{
+		{
+			name:    "json metadata before the records",
+			file:    "input.json",
+			content: `{"metadata":{"database_type":"Test","ip_version":6},"records":[{"network": ]`,
+			want:    Metadata{DatabaseType: "Test", IPVersion: intPtr(6)},
+		},
+		{
+			name:    "json metadata after the records",
+			file:    "input.json",
+			content: `{"records":[{"network":"1.0.0.0/24","data":{"a":[1,{"b":null}]}}],"metadata":{"database_type":"Test"}}`,
+			want:    Metadata{DatabaseType: "Test"},
+		},
+		{
+			name:    "json array of records",
+			file:    "input.json",
+			content: `[{"network":"1.0.0.0/24","data":{"a":1}}]`,
+		},
+		{
+			name:    "json unknown metadata key",
+			file:    "input.json",
+			content: `{"metadata":{"database_typ":"Test"},"records":[]}`,
+			wantErr: `unknown metadata field "database_typ"`,
+		},
+		{
+			name:    "json syntax error before the metadata",
+			file:    "input.json",
+			content: `{"records":[}`,
+			wantErr: "parsing JSON",
+		},
+		{
+			name:    "jsonl header",
+			format:  "jsonl",
+			file:    "input.jsonl",
+			content: `{"metadata":{"database_type":"Test"}}` + "\n" + `not json` + "\n",
+			want:    Metadata{DatabaseType: "Test"},
+		},
+		{
+			name:    "rir metadata without reading the file",
+			format:  "rir",
+			file:    "delegated.txt",
+			content: "arin|US|ipv4|not an address|1|20200101|allocated\n",
+			want:    (&rirRecordReader{}).Metadata(),
+		},
+		{
+			name:    "cloud metadata",
+			format:  "aws",
+			file:    "ip-ranges.json",
+			content: "not json",
+			want:    (&cloudRecordReader{provider: "aws"}).Metadata(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readInputMetadata(writeTestFile(t, tt.file, tt.content), InputOptions{Format: tt.format})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listRecordReader reads a plain list of networks, one CIDR, address or
+// range per line, and gives every network the same data. Comments start
+// with # or ; (as in Spamhaus DROP), anything after the network is ignored.
+type listRecordReader struct {
+	file    io.ReadCloser
+	scanner *bufio.Scanner
+	data    map[string]any
+	line    int
+}
+
+func newListRecordReader(path string, data map[string]any) (*listRecordReader, error) {
+	if data == nil {
+		return nil, fmt.Errorf("--format list requires --data")
+	}
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	return &listRecordReader{file: f, scanner: bufio.NewScanner(f), data: data}, nil
+}
+
+// parseListData parses the --data JSON object
+func parseListData(text string) (map[string]any, error) {
+	var data map[string]any
+	if err := unmarshalJSON([]byte(text), &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return data, nil
+}
+
+func (r *listRecordReader) Metadata() Metadata {
+	return Metadata{
+		DatabaseType: "Network-List",
+		Description:  map[string]string{"en": "Networks imported from a list"},
+	}
+}
+
+func (r *listRecordReader) Next() (JSONRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := r.scanner.Text()
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		return JSONRecord{Network: fields[0], Data: copyData(r.data)}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return JSONRecord{}, fmt.Errorf("reading line %d: %w", r.line+1, err)
+	}
+	return JSONRecord{}, io.EOF
+}
+
+// copyData copies the maps and slices of record data, so callers can change
+// the copy
+func copyData(data map[string]any) map[string]any {
+	copied := make(map[string]any, len(data))
+	for key, value := range data {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return copyData(v)
+	case []any:
+		copied := make([]any, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+func (r *listRecordReader) Location() string {
+	return fmt.Sprintf("line %d", r.line)
+}
+
+func (r *listRecordReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// LookupResult is the JSON output for one looked up IP address
+type LookupResult struct {
+	IP      string      `json:"ip"`
+	Found   bool        `json:"found"`
+	Network string      `json:"network,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	// Fields holds the values selected with --field instead of Data
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// lookupIPs looks up IP addresses in an MMDB file. The addresses come from
+// ips, followed by the lines of ipsFile ("-" for stdin). When neither is
+// given, addresses are read from stdin. With fields only the selected values
+// are decoded and printed, one line per address.
+func lookupIPs(filepath string, ips []string, ipsFile string, fields []FieldPath, jsonOutput bool) error {
+	reader, err := maxminddb.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("opening MMDB file: %w", err)
+	}
+	defer reader.Close()
+
+	if len(ips) == 0 && ipsFile == "" {
+		ipsFile = "-"
+	}
+	if ipsFile != "" {
+		fileIPs, err := readIPList(ipsFile)
+		if err != nil {
+			return err
+		}
+		ips = append(ips, fileIPs...)
+	}
+
+	results := make([]LookupResult, 0, len(ips))
+	for _, ip := range ips {
+		result := lookupIP(reader, ip, fields)
+		if jsonOutput {
+			results = append(results, result)
+			continue
+		}
+		if len(fields) > 0 {
+			// Keep one line per address so the output lines up with the input
+			if result.Error != "" {
+				log.Printf("%s: %s", warnColor(result.IP), errorColor(result.Error))
+			}
+			fmt.Printf("%s\n", formatFieldValues(result.Fields, fields))
+			continue
+		}
+		printLookupResult(result)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling JSON: %w", err)
+		}
+		fmt.Printf("%s\n", string(jsonData))
+	}
+
+	return nil
+}
+
+func lookupIP(reader *maxminddb.Reader, ip string, fields []FieldPath) LookupResult {
+	result := LookupResult{IP: ip}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid IP address: %v", err)
+		return result
+	}
+
+	lookup := reader.Lookup(addr)
+	if err := lookup.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Network = lookup.Prefix().String()
+	if !lookup.Found() {
+		return result
+	}
+	result.Found = true
+
+	if len(fields) > 0 {
+		result.Fields = decodeFields(lookup, fields)
+		return result
+	}
+
+	var record interface{}
+	if err := lookup.Decode(&record); err != nil {
+		result.Error = fmt.Sprintf("decoding record: %v", err)
+		return result
+	}
+	result.Data = record
+	return result
+}
+
+func printLookupResult(result LookupResult) {
+	switch {
+	case result.Error != "":
+		fmt.Printf("%s  %s\n", warnColor(result.IP), errorColor(result.Error))
+	case !result.Found:
+		fmt.Printf("%s  %s: %s\n", warnColor(result.IP), infoColor(result.Network), warnColor("not found"))
+	default:
+		fmt.Printf("%s  %s: %v\n", successColor(result.IP), infoColor(result.Network), result.Data)
+	}
+}
+
+// readIPList reads one IP address per line, skipping empty lines and
+// # comments. A path of "-" reads from stdin.
+func readIPList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening IP list: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var ips []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		ips = append(ips, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading IP list: %w", err)
+	}
+	return ips, nil
+}
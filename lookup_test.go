@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+func TestLookupIP(t *testing.T) {
+	path := writeTestDatabase(t, "test.mmdb", map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"name": mmdbtype.String("v4"), "country": mmdbtype.Map{"iso_code": mmdbtype.String("US")}},
+		"2a00::/32":  {"name": mmdbtype.String("v6")},
+	})
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		name    string
+		ip      string
+		fields  []string
+		want    LookupResult
+		wantErr string
+	}{
+		{
+			name: "ipv4 address in an ipv6 tree",
+			ip:   "1.0.0.1",
+			want: LookupResult{IP: "1.0.0.1", Found: true, Network: "1.0.0.0/24", Data: map[string]any{"name": "v4", "country": map[string]any{"iso_code": "US"}}},
+		},
+		{
+			name: "ipv6 address",
+			ip:   "2a00::1",
+			want: LookupResult{IP: "2a00::1", Found: true, Network: "2a00::/32", Data: map[string]any{"name": "v6"}},
+		},
+		{
+			name: "missing network",
+			ip:   "2.0.0.1",
+			want: LookupResult{IP: "2.0.0.1", Network: "2.0.0.0/7"},
+		},
+		{
+			name:   "selected fields",
+			ip:     "1.0.0.1",
+			fields: []string{"country.iso_code", "missing"},
+			want:   LookupResult{IP: "1.0.0.1", Found: true, Network: "1.0.0.0/24", Fields: map[string]any{"country.iso_code": "US", "missing": nil}},
+		},
+		{
+			name:    "invalid address",
+			ip:      "1.0.0",
+			wantErr: "invalid IP address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseFieldPaths(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := lookupIP(reader, tt.ip, fields)
+			if tt.wantErr != "" {
+				if !strings.Contains(got.Error, tt.wantErr) || got.Found {
+					t.Fatalf("got %+v, want error %q", got, tt.wantErr)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadIPList(t *testing.T) {
+	path := writeTestFile(t, "ips.txt", "# addresses to check\n1.0.0.1\n\n  2a00::1  \n1.0.0.2 # office\n   \nnot-an-ip\n#1.0.0.3\n")
+	ips, err := readIPList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Invalid addresses are kept, lookupIP reports them in input order
+	want := []string{"1.0.0.1", "2a00::1", "1.0.0.2", "not-an-ip"}
+	if !reflect.DeepEqual(ips, want) {
+		t.Errorf("ips = %q, want %q", ips, want)
+	}
+
+	if _, err := readIPList(path + ".missing"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
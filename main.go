@@ -2,26 +2,38 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/fatih/color"
 	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/oschwald/maxminddb-golang/v2"
 )
 
 type Metadata struct {
-	DatabaseType   string            `json:"database_type"`
-	Description    map[string]string `json:"description"`
-	Languages      []string          `json:"languages,omitempty"`
-	BuildTimestamp *int64            `json:"build_epoch,omitempty"`
+	DatabaseType   string            `json:"database_type" yaml:"database_type"`
+	Description    map[string]string `json:"description" yaml:"description"`
+	Languages      []string          `json:"languages,omitempty" yaml:"languages"`
+	BuildTimestamp *int64            `json:"build_epoch,omitempty" yaml:"build_epoch"`
+	// IPVersion and RecordSize override the detected IP version and the
+	// default record size, -r and the writer settings of a build file
+	// override them in turn
+	IPVersion  *int `json:"ip_version,omitempty" yaml:"ip_version"`
+	RecordSize *int `json:"record_size,omitempty" yaml:"record_size"`
+	// The binary format is always 2.0, other versions are rejected
+	BinaryFormatMajorVersion *int `json:"binary_format_major_version,omitempty" yaml:"binary_format_major_version"`
+	BinaryFormatMinorVersion *int `json:"binary_format_minor_version,omitempty" yaml:"binary_format_minor_version"`
 }
 
 type InputData struct {
@@ -30,13 +42,19 @@ type InputData struct {
 }
 
 type JSONRecord struct {
-	Network string         `json:"network"`
+	// Network is a CIDR, a single IP address or a "start-end" range
+	Network string `json:"network,omitempty"`
+	// StartIP and EndIP give a range instead of Network
+	StartIP string         `json:"start_ip,omitempty"`
+	EndIP   string         `json:"end_ip,omitempty"`
 	Data    map[string]any `json:"data"`
+	// Op is how the record is applied: insert (default), merge or remove
+	Op string `json:"op,omitempty"`
 }
 
 type ValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 // Add color variables
@@ -122,25 +140,30 @@ func validateMetadata(m Metadata) error {
 		}
 	}
 
+	ve := &ValidationErrors{}
+	validateMetadataFormat(m, ve)
+	if ve.HasErrors() {
+		return &ve.Errors[0]
+	}
+
 	return nil
 }
 
 func validateRecord(record JSONRecord) error {
-	// Validate Network (CIDR)
-	if record.Network == "" {
-		return &ValidationError{
-			Field:   "network",
-			Message: "network is required",
-		}
+	// Validate Network (CIDR, IP address or range)
+	if _, err := recordRange(record); err != nil {
+		return err
 	}
 
-	_, _, err := net.ParseCIDR(record.Network)
-	if err != nil {
+	if field, message := validateRecordOp(record); field != "" {
 		return &ValidationError{
-			Field:   "network",
-			Message: fmt.Sprintf("invalid CIDR format: %v", err),
+			Field:   field,
+			Message: message,
 		}
 	}
+	if record.Op == opRemove {
+		return nil
+	}
 
 	// Validate Data
 	if record.Data == nil {
@@ -174,7 +197,23 @@ func validateDataStructure(data interface{}, path string) error {
 	}
 
 	switch v := data.(type) {
+	case json.Number:
+		if _, err := convertNumber(v); err != nil {
+			return &ValidationError{
+				Field:   path,
+				Message: err.Error(),
+			}
+		}
 	case map[string]interface{}:
+		if isTypedValue(v) {
+			if _, err := convertTypedValue(v); err != nil {
+				return &ValidationError{
+					Field:   path,
+					Message: err.Error(),
+				}
+			}
+			return nil
+		}
 		if len(v) == 0 {
 			return &ValidationError{
 				Field:   path,
@@ -232,13 +271,17 @@ func main() {
 	app.UsageWriter(os.Stdout)
 
 	// Add check mode flag
-	checkFile := app.Flag("check", "Check JSON file for errors without building MMDB").
+	var checkFiles, inputFiles inputPathsValue
+	app.Flag("check", "Check JSON files for errors without building MMDB, like --input").
 		Short('c').
-		ExistingFile()
+		SetValue(&checkFiles)
 
-	inputFile := app.Flag("input", "Input JSON file path").
+	app.Flag("input", "Input JSON file, glob or directory (a directory for maxmind-csv), - for stdin, may be compressed (repeatable)").
 		Short('i').
-		ExistingFile()
+		SetValue(&inputFiles)
+
+	primaryFile := app.Flag("primary", "Input file whose metadata is used with several inputs, instead of merging their metadata").
+		String()
 
 	verifyFile := app.Flag("verify", "Verify and display MMDB file information").
 		Short('v').
@@ -248,7 +291,21 @@ func main() {
 		Short('V').
 		ExistingFile()
 
-	jsonOutput := app.Flag("json", "Output in JSON format").
+	lookupFile := app.Flag("lookup", "Look up IP addresses (arguments, --ips-file or stdin) in an MMDB file").
+		Short('l').
+		ExistingFile()
+
+	exportFile := app.Flag("export", "Export an MMDB file to stdout in the input format (see --format)").
+		Short('e').
+		ExistingFile()
+
+	ipsFile := app.Flag("ips-file", "File with one IP address per line to look up, - for stdin").
+		String()
+
+	fieldPaths := app.Flag("field", "Only decode and print this field with -l or -V, e.g. country.iso_code (repeatable)").
+		Strings()
+
+	jsonOutput := app.Flag("json", "Output in JSON format with -c, -v, -V or -l").
 		Bool()
 
 	outputFile := app.Flag("output", "Output MMDB file path").
@@ -256,11 +313,85 @@ func main() {
 		Default("output.mmdb").
 		String()
 
-	recordSize := app.Flag("record-size", "Record size (24, 28, or 32)").
+	recordSize := app.Flag("record-size", "Record size (24, 28, or 32), overrides the metadata record_size, defaults to the record size of --base or 28").
 		Short('r').
-		Default("28").
 		Enum("24", "28", "32")
 
+	inputFormat := app.Flag("format", "Input and export format (json, jsonl with one record per line, csv or tsv with --mapping, maxmind-csv, rir, bgp, aws, gcp, azure, oracle, list with --data)").
+		Default("json").
+		Enum(inputFormats...)
+
+	mappingFile := app.Flag("mapping", "Mapping file (JSON or YAML) from csv and tsv columns to data fields").
+		ExistingFile()
+
+	profile := app.Flag("profile", "Built-in mapping for a vendor range CSV, implies --format csv").
+		Enum(profileNames()...)
+
+	rirFiles := app.Flag("rir", "RIR delegated-*-extended file adding the registry and country of AS numbers with --format rir or bgp (repeatable)").
+		ExistingFiles()
+
+	asNamesFile := app.Flag("as-names", "File of \"<asn> <organization>\" lines naming AS numbers with --format rir or bgp").
+		ExistingFile()
+
+	listData := app.Flag("data", "JSON object given to every network of --format list, e.g. {\"blocklist\":\"spamhaus-drop\"}").
+		String()
+
+	metadataFile := app.Flag("metadata", "JSON file with the metadata object, overrides metadata found in the input").
+		ExistingFile()
+
+	intType := app.Flag("int-type", "MMDB type for integer values, auto picks the smallest fitting type").
+		Default("auto").
+		Enum(integerTypes...)
+
+	floatType := app.Flag("float-type", "MMDB type for fractional values").
+		Default("double").
+		Enum(floatTypes...)
+
+	mergeStrategy := app.Flag("merge", "How a record is merged into earlier overlapping networks").
+		Default("replace").
+		Enum(mergeStrategyNames()...)
+
+	schemaFile := app.Flag("schema", "Schema file (JSON or YAML) declaring the type of each data field").
+		ExistingFile()
+
+	baseFile := app.Flag("base", "Existing MMDB file the records of --input are applied to").
+		ExistingFile()
+
+	reproducible := app.Flag("reproducible", "Build a byte-identical database on every run, requires build_epoch in the metadata or SOURCE_DATE_EPOCH").
+		Bool()
+
+	verifyReproducible := app.Flag("verify-reproducible", "Build twice with --reproducible and fail if the SHA-256 of the builds differ").
+		Bool()
+
+	checksum := app.Flag("checksum", "Write the SHA-256 of the output to OUTPUT.sha256").
+		Bool()
+
+	manifest := app.Flag("manifest", "Write OUTPUT.manifest.json with the input hashes, record count, metadata and build duration").
+		Bool()
+
+	signKeyFile := app.Flag("sign-key", "ed25519 private key (PEM) signing the output, the signature is written to OUTPUT.sig").
+		ExistingFile()
+
+	pubkeyFile := app.Flag("pubkey", "ed25519 public key (PEM) checking the signature of the file given to -v or -V").
+		ExistingFile()
+
+	signatureFile := app.Flag("signature", "Signature file checked with --pubkey, defaults to FILE.sig").
+		String()
+
+	ipArgs := app.Arg("ips", "IP addresses to look up with --lookup").
+		Strings()
+
+	// "mmdbimport build" builds the targets of a build file, "mmdbimport
+	// diff" compares two databases
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		runBuildCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiffCommand(os.Args[2:])
+		return
+	}
+
 	// Show usage if no args or --help
 	if len(os.Args) == 1 {
 		app.Usage(os.Args[1:])
@@ -269,14 +400,46 @@ func main() {
 
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
+	numberPolicy = NumberPolicy{
+		Integer: *intType,
+		Float:   *floatType,
+	}
+
+	var listDataMap map[string]any
+	if *listData != "" {
+		data, err := parseListData(*listData)
+		if err != nil {
+			log.Fatal(errorColor(fmt.Sprintf("Error parsing --data: %v", err)))
+		}
+		listDataMap = data
+	}
+	inputOpts, baseIPVersion, err := loadInputOptions(InputSettings{
+		Format:       *inputFormat,
+		MappingFile:  *mappingFile,
+		Profile:      *profile,
+		SchemaFile:   *schemaFile,
+		MetadataFile: *metadataFile,
+		ListData:     listDataMap,
+		RIRFiles:     *rirFiles,
+		ASNamesFile:  *asNamesFile,
+		PrimaryFile:  *primaryFile,
+		BaseFile:     *baseFile,
+	})
+	if err != nil {
+		log.Fatal(errorColor(err.Error()))
+	}
+
+	fields, err := parseFieldPaths(*fieldPaths)
+	if err != nil {
+		log.Fatal(errorColor(err.Error()))
+	}
+
 	// Count how many mode flags are set
 	modeFlags := 0
-	if *checkFile != "" {
-		// log.Printf("checkFile: %s", *checkFile)
+	if len(checkFiles) > 0 {
 		modeFlags++
 	}
-	if *inputFile != "" {
-		// log.Printf("inputFile: %s", *inputFile)
+	if len(inputFiles) > 0 {
 		modeFlags++
 	}
 	if *verifyFile != "" {
@@ -285,152 +448,211 @@ func main() {
 	}
 	if *verifyVerbose != "" {
 		// log.Printf("verifyVerbose: %s", *verifyVerbose)
-		// *inputFile = *verifyFile
+		modeFlags++
+	}
+	if *lookupFile != "" {
+		modeFlags++
+	}
+	if *exportFile != "" {
 		modeFlags++
 	}
 	// log.Printf("modeFlags: %d", modeFlags)
 
 	// Validate mode flags
 	if modeFlags == 0 {
-		log.Fatal(errorColor("One of --check, --input, --verify, --verify-verbose flags must be provided"))
+		log.Fatal(errorColor("One of --check, --input, --verify, --verify-verbose, --lookup, --export flags must be provided"))
 	}
 	if modeFlags > 1 {
-		log.Fatal(errorColor("The --check, --input, --verify, --verify-verbose flags are mutually exclusive"))
+		log.Fatal(errorColor("The --check, --input, --verify, --verify-verbose, --lookup, --export flags are mutually exclusive"))
 	}
 
-	// Handle verify mode
-	if *verifyFile != "" {
-		if err := verifyMMDBFile(*verifyFile, false, *jsonOutput); err != nil {
-			log.Fatal(errorColor(fmt.Sprintf("Error verifying MMDB file: %v", err)))
-		}
-		os.Exit(0)
+	// -c takes its inputs the same way as -i
+	inputArgs := inputFiles
+	if len(checkFiles) > 0 {
+		inputArgs = checkFiles
 	}
-	// Handle verify verbose mode
-	if *verifyVerbose != "" {
-		if err := verifyMMDBFile(*verifyVerbose, true, *jsonOutput); err != nil {
-			log.Fatal(errorColor(fmt.Sprintf("Error verifying MMDB file: %v", err)))
+	var inputPaths []string
+	if len(inputArgs) > 0 {
+		inputPaths, err = expandInputPaths(inputArgs, inputOpts.Format)
+		if err != nil {
+			log.Fatal(errorColor(err.Error()))
 		}
-		os.Exit(0)
 	}
 
-	// Handle check mode
-	if *checkFile != "" {
-		if err := validateJSONFile(*checkFile); err != nil {
-			os.Exit(1)
+	// Input is read more than once, stdin is spooled to a temporary file.
+	// os.Exit skips deferred calls, so from here on exit and fatal remove it.
+	var stdinSpool string
+	exit := func(code int) {
+		if stdinSpool != "" {
+			os.Remove(stdinSpool)
 		}
-		fmt.Printf("%s %s\n", successColor("✓"), infoColor("JSON validation successful"))
-		os.Exit(0)
+		os.Exit(code)
 	}
-
-	// Regular build mode requires input file
-	if *inputFile == "" {
-		log.Fatal(errorColor("Input file is required for build mode. Use -i or --input"))
+	fatal := func(message string) {
+		log.Print(message)
+		exit(1)
 	}
-
-	// Validate input file before processing
-	if err := validateJSONFile(*inputFile); err != nil {
-		log.Fatal(errorColor("Invalid input file"))
+	for i, path := range inputPaths {
+		if path != "-" {
+			continue
+		}
+		if inputOpts.Format == "maxmind-csv" {
+			fatal(errorColor("--format maxmind-csv reads a directory and cannot read stdin"))
+		}
+		if stdinSpool != "" {
+			fatal(errorColor("stdin can only be given once as input"))
+		}
+		spooled, err := spoolStdin()
+		if err != nil {
+			fatal(errorColor(err.Error()))
+		}
+		stdinSpool, inputPaths[i] = spooled, spooled
 	}
-
-	// Convert recordSize from string to int
-	recordSizeInt := 28
-	switch *recordSize {
-	case "24":
-		recordSizeInt = 24
-	case "28":
-		recordSizeInt = 28
-	case "32":
-		recordSizeInt = 32
+	if stdinSpool != "" {
+		defer os.Remove(stdinSpool)
 	}
+	inputOpts.StdinSpool = stdinSpool
 
-	// Read and parse JSON file
-	inputData, err := readJSONFile(*inputFile)
-	if err != nil {
-		log.Fatal(errorColor(fmt.Sprintf("Error reading JSON file: %v", err)))
+	// The signature is checked before anything is printed
+	if *pubkeyFile != "" {
+		path := *verifyFile
+		if path == "" {
+			path = *verifyVerbose
+		}
+		if path == "" {
+			fatal(errorColor("--pubkey is only used with -v or -V"))
+		}
+		if *signatureFile == "" {
+			*signatureFile = path + ".sig"
+		}
+		key, err := loadPublicKey(*pubkeyFile)
+		if err != nil {
+			fatal(errorColor(err.Error()))
+		}
+		if err := verifySignatureFile(path, *signatureFile, key); err != nil {
+			log.Print(errorColor(fmt.Sprintf("Error verifying signature: %v", err)))
+			if errors.Is(err, errSignatureMismatch) {
+				exit(exitSignatureMismatch)
+			}
+			exit(1)
+		}
+	} else if *signatureFile != "" {
+		fatal(errorColor("--signature is only used with --pubkey"))
 	}
 
-	// Validate metadata
-	if err := validateMetadata(inputData.Metadata); err != nil {
-		log.Fatal(errorColor(fmt.Sprintf("Invalid metadata: %v", err)))
+	// Handle verify mode
+	if *verifyFile != "" {
+		if err := verifyMMDBFile(*verifyFile, false, nil, *signatureFile, *jsonOutput); err != nil {
+			fatal(errorColor(fmt.Sprintf("Error verifying MMDB file: %v", err)))
+		}
+		exit(0)
 	}
-
-	// Validate records
-	for i, record := range inputData.Records {
-		if err := validateRecord(record); err != nil {
-			log.Fatal(errorColor(fmt.Sprintf("Invalid record at index %d: %v", i, err)))
+	// Handle verify verbose mode
+	if *verifyVerbose != "" {
+		if err := verifyMMDBFile(*verifyVerbose, true, fields, *signatureFile, *jsonOutput); err != nil {
+			fatal(errorColor(fmt.Sprintf("Error verifying MMDB file: %v", err)))
 		}
+		exit(0)
 	}
 
-	// Detect IP version from records
-	ipVersion := detectIPVersion(inputData.Records)
-	log.Printf("%s: %d", infoColor("Detected IP version"), ipVersion)
-
-	// Set default metadata values
-	if inputData.Metadata.Languages == nil || len(inputData.Metadata.Languages) == 0 {
-		inputData.Metadata.Languages = []string{"en"}
+	// Handle lookup mode
+	if *lookupFile != "" {
+		if err := lookupIPs(*lookupFile, *ipArgs, *ipsFile, fields, *jsonOutput); err != nil {
+			fatal(errorColor(fmt.Sprintf("Error looking up IP addresses: %v", err)))
+		}
+		exit(0)
 	}
-	if inputData.Metadata.BuildTimestamp == nil {
-		now := time.Now().Unix()
-		inputData.Metadata.BuildTimestamp = &now
+	if len(*ipArgs) > 0 {
+		fatal(errorColor("IP address arguments are only used with --lookup"))
 	}
 
-	// Create MMDB writer with metadata (note: BinaryVersion is always 2)
-	writer, err := mmdbwriter.New(mmdbwriter.Options{
-		DatabaseType: inputData.Metadata.DatabaseType,
-		Description:  inputData.Metadata.Description,
-		Languages:    inputData.Metadata.Languages,
-		IPVersion:    ipVersion,
-		RecordSize:   recordSizeInt,
-		// BinaryFormatMajorVersion is not set as it defaults to 2 in mmdbwriter
-	})
-	if err != nil {
-		log.Fatalf("Error creating MMDB writer: %v", err)
+	// Handle export mode
+	if *exportFile != "" {
+		if *inputFormat != "json" && *inputFormat != "jsonl" {
+			fatal(errorColor("Export supports the json and jsonl formats"))
+		}
+		if err := exportDatabase(*exportFile, *inputFormat, os.Stdout); err != nil {
+			fatal(errorColor(fmt.Sprintf("Error exporting MMDB file: %v", err)))
+		}
+		exit(0)
 	}
 
-	// Process records
-	for i, record := range inputData.Records {
-		if err := processRecord(writer, record, i); err != nil {
-			log.Printf("Warning: Error processing record %d: %v", i, err)
+	// Handle check mode
+	if len(checkFiles) > 0 {
+		if err := checkInputFile(inputPaths, inputOpts, *jsonOutput); err != nil {
+			exit(1)
 		}
+		exit(0)
 	}
 
-	// Write the database to file
-	if err := writeDatabase(writer, *outputFile); err != nil {
-		log.Fatalf("Error writing database: %v", err)
+	// Regular build mode requires input file
+	if len(inputPaths) == 0 {
+		fatal(errorColor("Input file is required for build mode. Use -i or --input"))
+	}
+
+	buildOpts := BuildOptions{
+		Inputs:        inputPaths,
+		Input:         inputOpts,
+		Merge:         *mergeStrategy,
+		Numbers:       numberPolicy,
+		BaseFile:      *baseFile,
+		BaseIPVersion: baseIPVersion,
+		Output:        *outputFile,
+		Reproducible:  *reproducible || *verifyReproducible,
+		Checksum:      *checksum,
+		Manifest:      *manifest,
+	}
+	if *signKeyFile != "" {
+		key, err := loadSigningKey(*signKeyFile)
+		if err != nil {
+			fatal(errorColor(err.Error()))
+		}
+		buildOpts.SignKey = key
+	}
+	// Without -r the record size comes from the metadata, the base
+	// database or the default
+	if *recordSize != "" {
+		buildOpts.Writer.RecordSize, _ = strconv.Atoi(*recordSize)
+	}
+	if *verifyReproducible {
+		if _, err := verifyReproducibleBuild(buildOpts); err != nil {
+			fatal(errorColor(err.Error()))
+		}
+		return
+	}
+	if _, err := buildDatabase(buildOpts); err != nil {
+		fatal(errorColor(err.Error()))
 	}
-
-	log.Printf("%s: %s", successColor("Successfully created MMDB file"), *outputFile)
 }
 
-func detectIPVersion(records []JSONRecord) int {
-	hasIPv4 := false
-	hasIPv6 := false
-
-	for _, record := range records {
-		ip, _, err := net.ParseCIDR(record.Network)
-		if err != nil {
-			continue
-		}
+// ipVersionTracker records which address families were seen, so the IP
+// version can be detected while streaming through records.
+type ipVersionTracker struct {
+	hasIPv4 bool
+	hasIPv6 bool
+}
 
-		if ip.To4() != nil {
-			hasIPv4 = true
-		} else {
-			hasIPv6 = true
-		}
+func (t *ipVersionTracker) Add(record JSONRecord) {
+	network, err := recordRange(record)
+	if err != nil {
+		return
+	}
 
-		// If we found both types, we can stop searching
-		if hasIPv4 && hasIPv6 {
-			break
-		}
+	if network.From().Unmap().Is4() {
+		t.hasIPv4 = true
+	} else {
+		t.hasIPv6 = true
 	}
+}
 
+func (t *ipVersionTracker) Version() int {
 	// Decision logic
 	switch {
-	case hasIPv4 && hasIPv6:
+	case t.hasIPv4 && t.hasIPv6:
 		return 6 // MMDB supports both when set to 6
-	case hasIPv6:
+	case t.hasIPv6:
 		return 6
-	case hasIPv4:
+	case t.hasIPv4:
 		return 4
 	default:
 		return 6 // Default to IPv6 if no valid IPs found
@@ -438,16 +660,20 @@ func detectIPVersion(records []JSONRecord) int {
 }
 
 func readJSONFile(filepath string) (InputData, error) {
-	data, err := os.ReadFile(filepath)
+	data, err := readInput(filepath)
 	if err != nil {
 		return InputData{}, fmt.Errorf("reading file: %w", err)
 	}
 
 	var input InputData
-	if err := json.Unmarshal(data, &input); err != nil {
-		// Try legacy format (just array of records)
+	if !isJSONArray(data) {
+		if err := unmarshalJSON(data, &input); err != nil {
+			return InputData{}, fmt.Errorf("parsing JSON: %w", err)
+		}
+	} else {
+		// Legacy format (just array of records)
 		var records []JSONRecord
-		if err := json.Unmarshal(data, &records); err != nil {
+		if err := unmarshalJSON(data, &records); err != nil {
 			return InputData{}, fmt.Errorf("parsing JSON: %w", err)
 		}
 		input.Records = records
@@ -456,33 +682,33 @@ func readJSONFile(filepath string) (InputData, error) {
 	return input, nil
 }
 
-func processRecord(writer *mmdbwriter.Tree, record JSONRecord, index int) error {
-	_, network, err := net.ParseCIDR(record.Network)
+func processRecord(writer *mmdbwriter.Tree, record JSONRecord, index int, merge inserter.FuncGenerator) error {
+	network, err := recordRange(record)
 	if err != nil {
-		return fmt.Errorf(errorColor("parsing network %s: %v"), record.Network, err)
+		return fmt.Errorf(errorColor("parsing network: %v"), err)
 	}
 
-	data, err := convertToMMDBType(record.Data)
-	if err != nil {
-		return fmt.Errorf(errorColor("converting data: %v"), err)
+	var data mmdbtype.DataType
+	if record.Op != opRemove {
+		data, err = convertToMMDBType(record.Data)
+		if err != nil {
+			return fmt.Errorf(errorColor("converting data: %v"), err)
+		}
 	}
 
-	if err := writer.Insert(network, data); err != nil {
+	if err := insertRange(writer, network, recordInserter(record.Op, data, merge)); err != nil {
 		return fmt.Errorf(errorColor("inserting record: %v"), err)
 	}
 
 	return nil
 }
 
+// writeDatabase writes the database atomically, see writeFileAtomic
 func writeDatabase(writer *mmdbwriter.Tree, filepath string) error {
-	f, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("creating output file: %w", err)
-	}
-	defer f.Close()
-
-	_, err = writer.WriteTo(f)
-	return err
+	return writeFileAtomic(filepath, func(w io.Writer) error {
+		_, err := writer.WriteTo(w)
+		return err
+	})
 }
 
 func convertToMMDBType(data interface{}) (mmdbtype.DataType, error) {
@@ -493,6 +719,8 @@ func convertToMMDBType(data interface{}) (mmdbtype.DataType, error) {
 	switch v := data.(type) {
 	case string:
 		return mmdbtype.String(v), nil
+	case json.Number: // Numbers decoded from JSON input
+		return convertNumber(v)
 	case int:
 		return mmdbtype.Int32(v), nil
 	case int32:
@@ -511,7 +739,10 @@ func convertToMMDBType(data interface{}) (mmdbtype.DataType, error) {
 		return mmdbtype.Bool(v), nil
 	case []interface{}: // Handle arrays of any type
 		return convertSlice(v)
-	case map[string]interface{}: // Handle nested objects
+	case map[string]interface{}: // Handle nested objects and typed values
+		if isTypedValue(v) {
+			return convertTypedValue(v)
+		}
 		return convertMap(v)
 	case []byte: // Handle binary data
 		return mmdbtype.Bytes(v), nil
@@ -535,7 +766,15 @@ func convertSlice(slice []interface{}) (mmdbtype.Slice, error) {
 
 func convertMap(m map[string]interface{}) (mmdbtype.Map, error) {
 	result := make(mmdbtype.Map)
-	for key, value := range m {
+	// Keys are converted in order, so the same input always reports the
+	// same error
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := m[key]
 		converted, err := convertToMMDBType(value)
 		if err != nil {
 			return nil, fmt.Errorf("converting map key %s: %w", key, err)
@@ -585,12 +824,18 @@ func convertReflectSlice(val reflect.Value) (mmdbtype.Slice, error) {
 func convertReflectMap(val reflect.Value) (mmdbtype.Map, error) {
 	result := make(mmdbtype.Map)
 
+	// Keys are converted to strings and then in order, like convertMap
+	keys := make(map[string]reflect.Value, val.Len())
+	keyStrs := make([]string, 0, val.Len())
 	for _, key := range val.MapKeys() {
-		// Convert key to string
 		keyStr := fmt.Sprintf("%v", key.Interface())
-
+		keys[keyStr] = key
+		keyStrs = append(keyStrs, keyStr)
+	}
+	sort.Strings(keyStrs)
+	for _, keyStr := range keyStrs {
 		// Convert value
-		value := val.MapIndex(key).Interface()
+		value := val.MapIndex(keys[keyStr]).Interface()
 		converted, err := convertToMMDBType(value)
 		if err != nil {
 			return nil, fmt.Errorf("converting reflect map key %s: %w", keyStr, err)
@@ -633,59 +878,126 @@ func convertReflectStruct(val reflect.Value) (mmdbtype.Map, error) {
 	return result, nil
 }
 
-// New function to validate JSON file and collect all errors
-func validateJSONFile(filepath string) error {
-	inputData, err := readJSONFile(filepath)
+// InputSummary holds what scanInputFile learned about an input.
+type InputSummary struct {
+	Metadata      Metadata
+	MetadataValid bool
+	IPVersion     int
+	Records       int
+}
+
+// scanInputFile streams through an input file and collects all errors. When
+// index is not nil, the networks of all records are added to it.
+func scanInputFile(paths []string, opts InputOptions, index *OverlapIndex) (InputSummary, *ValidationErrors, error) {
+	reader, err := openRecordReader(paths, opts)
 	if err != nil {
-		log.Printf("%s: Error reading JSON file: %v", errorColor("Error"), err)
-		return err
+		return InputSummary{}, nil, err
 	}
-
-	// Print file info
-	fmt.Printf("%s %s\n", infoColor("Input file:"), filepath)
+	defer reader.Close()
 
 	ve := &ValidationErrors{}
+	summary := InputSummary{Metadata: reader.Metadata()}
 
 	// Validate metadata
-	if err := validateMetadataCollectErrors(inputData.Metadata, ve); err != nil {
-		return err
+	if err := validateMetadataCollectErrors(summary.Metadata, ve); err != nil {
+		return summary, ve, err
+	}
+	summary.MetadataValid = !ve.HasErrors()
+
+	// Validate all records
+	tracker := &ipVersionTracker{}
+	recordIndex := -1
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		// Records that fail to parse still take up a position, so the
+		// overlap report numbers records as they appear in the input
+		recordIndex++
+		if err != nil {
+			var recordErr *ValidationError
+			if errors.As(err, &recordErr) {
+				ve.Add(recordErr.Field, recordErr.Message)
+				continue
+			}
+			return summary, ve, err
+		}
+
+		summary.Records++
+		tracker.Add(record)
+		if err := validateRecordCollectErrors(record, reader.Location(), ve); err != nil {
+			return summary, ve, err
+		}
+		if opts.Schema != nil && record.Data != nil {
+			opts.Schema.Validate(record.Data, reader.Location()+".data", ve)
+		}
+		if index != nil {
+			index.Add(record, recordIndex)
+		}
+	}
+	summary.IPVersion = tracker.Version()
+
+	return summary, ve, nil
+}
+
+// validateInputFile validates an input file and prints its summary and all
+// errors found.
+func validateInputFile(paths []string, opts InputOptions) (InputSummary, error) {
+	summary, ve, err := scanInputFile(paths, opts, nil)
+	if err != nil {
+		log.Printf("%s: Error reading input file: %v", errorColor("Error"), err)
+		return summary, err
+	}
+
+	return summary, printInputSummary(opts.inputNames(paths), summary, ve)
+}
+
+func printInputSummary(paths []string, summary InputSummary, ve *ValidationErrors) error {
+	// Print file info
+	if len(paths) == 1 {
+		fmt.Printf("%s %s\n", infoColor("Input file:"), paths[0])
+	} else {
+		fmt.Printf("%s\n", infoColor("Input files:"))
+		for _, path := range paths {
+			fmt.Printf("  %s\n", path)
+		}
 	}
 
 	// Print metadata info if no validation errors
-	if !ve.HasErrors() {
-		// Detect IP version
-		ipVersion := detectIPVersion(inputData.Records)
-		ipVersionStr := fmt.Sprintf("%d", ipVersion)
-		if ipVersion == 6 {
+	if summary.MetadataValid {
+		ipVersionStr := fmt.Sprintf("%d", summary.IPVersion)
+		if summary.IPVersion == 6 {
 			ipVersionStr += " (supports both IPv4 and IPv6)"
 		}
 
 		fmt.Printf("\n%s\n", infoColor("Database Information:"))
 		fmt.Printf("  IP Version: %s\n", successColor(ipVersionStr))
-		fmt.Printf("  Total Records: %s\n", successColor(fmt.Sprintf("%d", len(inputData.Records))))
+		fmt.Printf("  Total Records: %s\n", successColor(fmt.Sprintf("%d", summary.Records)))
 
 		fmt.Printf("\n%s\n", infoColor("Metadata:"))
-		fmt.Printf("  Database Type: %s\n", successColor(inputData.Metadata.DatabaseType))
+		fmt.Printf("  Database Type: %s\n", successColor(summary.Metadata.DatabaseType))
 
 		fmt.Printf("  Description:\n")
-		for lang, desc := range inputData.Metadata.Description {
+		for lang, desc := range summary.Metadata.Description {
 			fmt.Printf("    %s: %s\n", successColor(lang), desc)
 		}
 
-		if len(inputData.Metadata.Languages) > 0 {
-			fmt.Printf("  Languages: %s\n", successColor(joinStrings(inputData.Metadata.Languages)))
+		if len(summary.Metadata.Languages) > 0 {
+			fmt.Printf("  Languages: %s\n", successColor(joinStrings(summary.Metadata.Languages)))
 		}
 
-		if inputData.Metadata.BuildTimestamp != nil {
-			timestamp := time.Unix(*inputData.Metadata.BuildTimestamp, 0)
+		if summary.Metadata.BuildTimestamp != nil {
+			timestamp := time.Unix(*summary.Metadata.BuildTimestamp, 0)
 			fmt.Printf("  Build Timestamp: %s\n", successColor(timestamp.Format(time.RFC3339)))
 		}
-	}
 
-	// Validate all records
-	for i, record := range inputData.Records {
-		if err := validateRecordCollectErrors(record, i, ve); err != nil {
-			return err
+		if summary.Metadata.IPVersion != nil {
+			fmt.Printf("  IP Version: %s\n", successColor(fmt.Sprintf("%d", *summary.Metadata.IPVersion)))
+		}
+
+		if summary.Metadata.RecordSize != nil {
+			fmt.Printf("  Record Size: %s bits\n", successColor(fmt.Sprintf("%d", *summary.Metadata.RecordSize)))
 		}
 	}
 
@@ -754,19 +1066,31 @@ func validateMetadataCollectErrors(m Metadata, ve *ValidationErrors) error {
 		}
 	}
 
+	validateMetadataFormat(m, ve)
+
 	return nil
 }
 
-func validateRecordCollectErrors(record JSONRecord, index int, ve *ValidationErrors) error {
-	fieldPrefix := fmt.Sprintf("records[%d]", index)
-
-	if record.Network == "" {
+func validateRecordCollectErrors(record JSONRecord, fieldPrefix string, ve *ValidationErrors) error {
+	if record.Network == "" && record.StartIP == "" && record.EndIP == "" {
 		ve.Add(fieldPrefix+".network", "network is required")
 		return nil
 	}
 
-	if _, _, err := net.ParseCIDR(record.Network); err != nil {
-		ve.Add(fieldPrefix+".network", fmt.Sprintf("invalid CIDR format: %v", err))
+	if _, err := recordRange(record); err != nil {
+		var recordErr *ValidationError
+		if !errors.As(err, &recordErr) {
+			return err
+		}
+		ve.Add(fieldPrefix+"."+recordErr.Field, recordErr.Message)
+	}
+
+	if field, message := validateRecordOp(record); field != "" {
+		ve.Add(fieldPrefix+"."+field, message)
+		return nil
+	}
+	if record.Op == opRemove {
+		return nil
 	}
 
 	if record.Data == nil {
@@ -791,7 +1115,17 @@ func validateDataStructureCollectErrors(data interface{}, path string, ve *Valid
 	}
 
 	switch v := data.(type) {
+	case json.Number:
+		if _, err := convertNumber(v); err != nil {
+			ve.Add(path, err.Error())
+		}
 	case map[string]interface{}:
+		if isTypedValue(v) {
+			if _, err := convertTypedValue(v); err != nil {
+				ve.Add(path, err.Error())
+			}
+			return
+		}
 		if len(v) == 0 {
 			ve.Add(path, "map cannot be empty")
 			return
@@ -826,7 +1160,9 @@ type VerifyOutput struct {
 	BuildTime     string            `json:"build_time"`
 	BuildTimeAge  int               `json:"build_time_age"`
 	TotalNetworks int               `json:"total_networks"`
-	Networks      []NetworkEntry    `json:"networks,omitempty"`
+	// Signature is the verified signature file
+	Signature string         `json:"signature,omitempty"`
+	Networks  []NetworkEntry `json:"networks,omitempty"`
 }
 
 type NetworkEntry struct {
@@ -834,7 +1170,11 @@ type NetworkEntry struct {
 	Data    interface{} `json:"data"`
 }
 
-func verifyMMDBFile(filepath string, verbose bool, jsonOutput bool) error {
+// verifyMMDBFile prints the metadata of an MMDB file, and all its networks
+// when verbose. With fields only the selected values of each network are
+// decoded, and the human output is reduced to one line per network.
+// signature is the signature file that was checked with --pubkey, if any.
+func verifyMMDBFile(filepath string, verbose bool, fields []FieldPath, signature string, jsonOutput bool) error {
 	reader, err := maxminddb.Open(filepath)
 	if err != nil {
 		return fmt.Errorf("opening MMDB file: %w", err)
@@ -859,10 +1199,18 @@ func verifyMMDBFile(filepath string, verbose bool, jsonOutput bool) error {
 			// Build time age in seconds
 			BuildTimeAge:  int(time.Since(buildTime).Seconds()),
 			TotalNetworks: networks,
+			Signature:     signature,
 		}
 		if verbose {
 			output.Networks = []NetworkEntry{}
 			for result := range reader.Networks() {
+				if len(fields) > 0 {
+					output.Networks = append(output.Networks, NetworkEntry{
+						Network: result.Prefix().String(),
+						Data:    decodeFields(result, fields),
+					})
+					continue
+				}
 				var record interface{}
 				if err := result.Decode(&record); err != nil {
 					continue
@@ -880,11 +1228,19 @@ func verifyMMDBFile(filepath string, verbose bool, jsonOutput bool) error {
 		}
 
 		fmt.Printf("%s\n", string(jsonOutput))
+	} else if verbose && len(fields) > 0 {
+		// One line per network for scripting
+		for result := range reader.Networks() {
+			fmt.Printf("%s\t%s\n", result.Prefix(), formatFieldValues(decodeFields(result, fields), fields))
+		}
 	} else {
 		// Print file info
 		fmt.Printf("%s %s\n", infoColor("MMDB file:"), filepath)
 
 		fmt.Printf("  Build Timestamp: %s\n", successColor(buildTime.Format(time.RFC3339)))
+		if signature != "" {
+			fmt.Printf("  Signature: %s\n", successColor("valid ("+signature+")"))
+		}
 
 		fmt.Printf("\n%s\n", infoColor("Database Information:"))
 		fmt.Printf("  Binary Format: %s\n", successColor(fmt.Sprintf("%d.%d",
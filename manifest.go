@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, which is synced and then renamed over path, so readers never
+// see a partly written file. A replaced file keeps its mode, new files get
+// 0644. Devices and pipes like /dev/stdout are written directly.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	return writeFileAtomicChecked(path, write, nil)
+}
+
+// writeFileAtomicChecked is writeFileAtomic running check on the temporary
+// file before it is renamed over path. When check fails path is left as it
+// was. A device or pipe cannot be checked before it is written to.
+func writeFileAtomicChecked(path string, write func(w io.Writer) error, check func(tmp string) error) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			if check != nil {
+				return fmt.Errorf("%s is not a regular file, it cannot be checked before it is replaced", path)
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			if err := write(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	tmp := f.Name()
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := write(f); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fail(fmt.Errorf("setting file mode: %w", err))
+	}
+	if err := f.Sync(); err != nil {
+		return fail(fmt.Errorf("syncing file: %w", err))
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing file: %w", err)
+	}
+	if check != nil {
+		if err := check(tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("renaming file into place: %w", err)
+	}
+
+	// Sync the directory so the rename survives a crash, not every
+	// platform supports it
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// writeChecksumFile writes path.sha256 in the format of sha256sum
+func writeChecksumFile(path, hash string) error {
+	return writeFileAtomic(path+".sha256", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s  %s\n", hash, filepath.Base(path))
+		return err
+	})
+}
+
+// Manifest is written next to a database with --manifest, so distribution
+// systems can tell what a file holds and where it comes from
+type Manifest struct {
+	File      string         `json:"file"`
+	SHA256    string         `json:"sha256"`
+	Size      int64          `json:"size"`
+	Records   int            `json:"records"`
+	Metadata  Metadata       `json:"metadata"`
+	NodeCount uint           `json:"node_count"`
+	Inputs    []ManifestFile `json:"inputs"`
+	Base      *ManifestFile  `json:"base,omitempty"`
+	// The other files the build read
+	Mapping      *ManifestFile  `json:"mapping,omitempty"`
+	Schema       *ManifestFile  `json:"schema,omitempty"`
+	MetadataFile *ManifestFile  `json:"metadata_file,omitempty"`
+	RIRFiles     []ManifestFile `json:"rir_files,omitempty"`
+	ASNames      *ManifestFile  `json:"as_names,omitempty"`
+	// Signature is the file name of the detached signature
+	Signature       string `json:"signature,omitempty"`
+	BuildStarted    string `json:"build_started"`
+	BuildDurationMs int64  `json:"build_duration_ms"`
+}
+
+// ManifestFile is an input of a build
+type ManifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// writeManifest writes path.manifest.json for a finished build. The
+// metadata is read back from the written database.
+func writeManifest(opts BuildOptions, result BuildResult, hash string, started time.Time) error {
+	reader, err := maxminddb.Open(opts.Output)
+	if err != nil {
+		return fmt.Errorf("opening MMDB file: %w", err)
+	}
+	defer reader.Close()
+
+	meta := reader.Metadata
+	buildEpoch := int64(meta.BuildEpoch)
+	ipVersion := int(meta.IPVersion)
+	recordSize := int(meta.RecordSize)
+	majorVersion := int(meta.BinaryFormatMajorVersion)
+	minorVersion := int(meta.BinaryFormatMinorVersion)
+	manifest := Manifest{
+		File:    filepath.Base(opts.Output),
+		SHA256:  hash,
+		Records: result.Records,
+		Metadata: Metadata{
+			DatabaseType:             meta.DatabaseType,
+			Description:              meta.Description,
+			Languages:                meta.Languages,
+			BuildTimestamp:           &buildEpoch,
+			IPVersion:                &ipVersion,
+			RecordSize:               &recordSize,
+			BinaryFormatMajorVersion: &majorVersion,
+			BinaryFormatMinorVersion: &minorVersion,
+		},
+		NodeCount:       meta.NodeCount,
+		Inputs:          []ManifestFile{},
+		BuildStarted:    started.UTC().Format(time.RFC3339),
+		BuildDurationMs: time.Since(started).Milliseconds(),
+	}
+	if info, err := os.Stat(opts.Output); err == nil {
+		manifest.Size = info.Size()
+	}
+	if opts.SignKey != nil {
+		manifest.Signature = filepath.Base(opts.Output) + ".sig"
+	}
+
+	for _, path := range opts.Inputs {
+		files, err := manifestFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			file.Path = opts.Input.inputName(file.Path)
+			manifest.Inputs = append(manifest.Inputs, file)
+		}
+	}
+	for _, file := range []struct {
+		path  string
+		entry **ManifestFile
+	}{
+		{opts.BaseFile, &manifest.Base},
+		{opts.Input.MappingFile, &manifest.Mapping},
+		{opts.Input.SchemaFile, &manifest.Schema},
+		{opts.Input.MetadataFile, &manifest.MetadataFile},
+		{opts.Input.ASNamesFile, &manifest.ASNames},
+	} {
+		if file.path == "" {
+			continue
+		}
+		files, err := manifestFiles(file.path)
+		if err != nil {
+			return err
+		}
+		*file.entry = &files[0]
+	}
+	for _, path := range opts.Input.RIRFiles {
+		files, err := manifestFiles(path)
+		if err != nil {
+			return err
+		}
+		manifest.RIRFiles = append(manifest.RIRFiles, files[0])
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling manifest: %w", err)
+	}
+	return writeFileAtomic(opts.Output+".manifest.json", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s\n", data)
+		return err
+	})
+}
+
+// manifestFiles hashes an input, the files of a directory (maxmind-csv)
+// are listed one by one
+func manifestFiles(path string) ([]ManifestFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		hash, err := fileSHA256(path)
+		if err != nil {
+			return nil, err
+		}
+		return []ManifestFile{{Path: path, SHA256: hash, Size: info.Size()}}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading input directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var files []ManifestFile
+	for _, name := range names {
+		file, err := manifestFiles(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file...)
+	}
+	return files, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	write := func(content string) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}
+	}
+
+	tests := []struct {
+		name     string
+		existing os.FileMode
+		write    func(w io.Writer) error
+		wantMode os.FileMode
+		want     string
+		wantErr  bool
+	}{
+		{name: "new file", write: write("new"), wantMode: 0o644, want: "new"},
+		{name: "replaced file keeps its mode", existing: 0o600, write: write("new"), wantMode: 0o600, want: "new"},
+		{name: "executable file", existing: 0o755, write: write("new"), wantMode: 0o755, want: "new"},
+		{
+			name:     "failed write keeps the old file",
+			existing: 0o640,
+			write: func(w io.Writer) error {
+				io.WriteString(w, "partial")
+				return errors.New("write failed")
+			},
+			wantMode: 0o640,
+			want:     "old",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.mmdb")
+			if tt.existing != 0 {
+				if err := os.WriteFile(path, []byte("old"), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+				// WriteFile applies the umask
+				if err := os.Chmod(path, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writeFileAtomic(path, tt.write)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error = %v, want an error %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.wantMode {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.wantMode)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestWriteManifestConfigFiles(t *testing.T) {
+	input := writeTestInput(t)
+	schema := writeTestFile(t, "schema.json", `{"fields":{"a":"uint32"}}`)
+	metadataFile := writeTestFile(t, "metadata.json", `{"metadata":{"database_type":"Test","description":{"en":"from file"}}}`)
+	output := filepath.Join(t.TempDir(), "test.mmdb")
+
+	opts, _, err := loadInputOptions(InputSettings{Format: "json", SchemaFile: schema, MetadataFile: metadataFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildDatabase(BuildOptions{
+		Inputs:   []string{input},
+		Input:    opts,
+		Merge:    "replace",
+		Numbers:  numberPolicy,
+		Output:   output,
+		Manifest: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output + ".manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Inputs) != 1 || manifest.Inputs[0].Path != input {
+		t.Errorf("inputs = %+v", manifest.Inputs)
+	}
+	if manifest.Schema == nil || manifest.Schema.Path != schema || manifest.Schema.SHA256 == "" {
+		t.Errorf("schema = %+v", manifest.Schema)
+	}
+	if manifest.MetadataFile == nil || manifest.MetadataFile.Path != metadataFile {
+		t.Errorf("metadata_file = %+v", manifest.MetadataFile)
+	}
+	if manifest.Mapping != nil || manifest.ASNames != nil || manifest.RIRFiles != nil || manifest.Base != nil {
+		t.Errorf("unused files listed: %s", data)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxmindCSVReader reads a directory of MaxMind GeoIP2/GeoLite2 CSV files,
+// e.g. GeoLite2-City-Blocks-IPv4.csv, GeoLite2-City-Blocks-IPv6.csv and
+// GeoLite2-City-Locations-en.csv. Blocks are joined to the locations of all
+// locales by geoname_id and turned into the nested GeoIP2 record structure.
+// ASN editions have no locations, their blocks are used as they are.
+type maxmindCSVReader struct {
+	edition   string
+	blocks    []string
+	locales   []string
+	locations map[string]*maxmindLocation
+	// countries maps country ISO codes to the geoname_id of the country
+	countries map[string]string
+
+	fileIndex int
+	file      io.ReadCloser
+	reader    *csv.Reader
+	header    map[string]int
+	line      int
+}
+
+// maxmindLocation holds one geoname_id of the locations files, with the
+// names of all locales.
+type maxmindLocation struct {
+	continentCode    string
+	countryISOCode   string
+	subdivision1Code string
+	subdivision2Code string
+	metroCode        string
+	timeZone         string
+	inEuropeanUnion  bool
+
+	continentNames    map[string]any
+	countryNames      map[string]any
+	subdivision1Names map[string]any
+	subdivision2Names map[string]any
+	cityNames         map[string]any
+}
+
+func newMaxMindCSVReader(path string) (*maxmindCSVReader, error) {
+	dir, err := maxmindDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &maxmindCSVReader{
+		locations: map[string]*maxmindLocation{},
+		countries: map[string]string{},
+	}
+	var locationFiles []string
+	if r.edition, r.blocks, locationFiles, err = findMaxMindFiles(dir); err != nil {
+		return nil, err
+	}
+
+	for _, file := range locationFiles {
+		locale := maxmindLocale(r.edition, file)
+		if err := r.loadLocations(file, locale); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filepath.Base(file), err)
+		}
+		r.locales = append(r.locales, locale)
+	}
+	r.findCountries()
+
+	return r, nil
+}
+
+// findMaxMindFiles finds the edition, blocks files and sorted locations files
+// of a MaxMind CSV directory.
+func findMaxMindFiles(dir string) (edition string, blocks []string, locationFiles []string, err error) {
+	for _, version := range []string{"IPv4", "IPv6"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*-Blocks-"+version+".csv"))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		for _, match := range matches {
+			matchEdition := strings.TrimSuffix(filepath.Base(match), "-Blocks-"+version+".csv")
+			if edition != "" && edition != matchEdition {
+				return "", nil, nil, fmt.Errorf("found blocks of %s and %s, only one edition per directory is supported", edition, matchEdition)
+			}
+			edition = matchEdition
+			blocks = append(blocks, match)
+		}
+	}
+	if len(blocks) == 0 {
+		return "", nil, nil, fmt.Errorf("no *-Blocks-IPv4.csv or *-Blocks-IPv6.csv files found in %s", dir)
+	}
+
+	locationFiles, err = filepath.Glob(filepath.Join(dir, edition+"-Locations-*.csv"))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	sort.Strings(locationFiles)
+	return edition, blocks, locationFiles, nil
+}
+
+// maxmindLocale returns the locale of a locations file, e.g. "en" for
+// GeoLite2-City-Locations-en.csv
+func maxmindLocale(edition, file string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), edition+"-Locations-"), ".csv")
+}
+
+// maxmindDir returns the directory of a MaxMind CSV input, which is given as
+// the directory or one of its files
+func maxmindDir(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return filepath.Dir(path), nil
+	}
+	return path, nil
+}
+
+// readMaxMindMetadata returns the metadata of a MaxMind CSV directory, which
+// only depends on its file names.
+func readMaxMindMetadata(path string) (Metadata, error) {
+	dir, err := maxmindDir(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	edition, _, locationFiles, err := findMaxMindFiles(dir)
+	if err != nil {
+		return Metadata{}, err
+	}
+	r := &maxmindCSVReader{edition: edition}
+	for _, file := range locationFiles {
+		r.locales = append(r.locales, maxmindLocale(edition, file))
+	}
+	return r.Metadata(), nil
+}
+
+// loadLocations adds the rows of a locations file for one locale
+func (r *maxmindCSVReader) loadLocations(file string, locale string) error {
+	f, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	header, err := readCSVHeader(reader)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		get := func(column string) string {
+			return csvCell(row, header, column)
+		}
+
+		id := get("geoname_id")
+		if id == "" {
+			continue
+		}
+		loc, ok := r.locations[id]
+		if !ok {
+			loc = &maxmindLocation{
+				continentCode:    get("continent_code"),
+				countryISOCode:   get("country_iso_code"),
+				subdivision1Code: get("subdivision_1_iso_code"),
+				subdivision2Code: get("subdivision_2_iso_code"),
+				metroCode:        get("metro_code"),
+				timeZone:         get("time_zone"),
+				inEuropeanUnion:  get("is_in_european_union") == "1",
+			}
+			r.locations[id] = loc
+		}
+		addName(&loc.continentNames, locale, get("continent_name"))
+		addName(&loc.countryNames, locale, get("country_name"))
+		addName(&loc.subdivision1Names, locale, get("subdivision_1_name"))
+		addName(&loc.subdivision2Names, locale, get("subdivision_2_name"))
+		addName(&loc.cityNames, locale, get("city_name"))
+	}
+}
+
+// findCountries registers the locations without subdivision and city as the
+// countries themselves. It runs once all locales are loaded, a city can have
+// no name in some locales.
+func (r *maxmindCSVReader) findCountries() {
+	ids := make([]string, 0, len(r.locations))
+	for id := range r.locations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		loc := r.locations[id]
+		if loc.countryISOCode == "" || loc.subdivision1Code != "" || loc.cityNames != nil {
+			continue
+		}
+		if _, ok := r.countries[loc.countryISOCode]; !ok {
+			r.countries[loc.countryISOCode] = id
+		}
+	}
+}
+
+func addName(names *map[string]any, locale string, name string) {
+	if name == "" {
+		return
+	}
+	if *names == nil {
+		*names = map[string]any{}
+	}
+	(*names)[locale] = name
+}
+
+func (r *maxmindCSVReader) Metadata() Metadata {
+	metadata := Metadata{
+		DatabaseType: r.edition,
+		Languages:    r.locales,
+	}
+	language := "en"
+	if len(r.locales) > 0 && !containsString(r.locales, language) {
+		language = r.locales[0]
+	}
+	metadata.Description = map[string]string{language: r.edition + " imported from MaxMind CSV"}
+	return metadata
+}
+
+func (r *maxmindCSVReader) Next() (JSONRecord, error) {
+	for {
+		if r.reader == nil {
+			if r.fileIndex >= len(r.blocks) {
+				return JSONRecord{}, io.EOF
+			}
+			if err := r.openBlocks(r.blocks[r.fileIndex]); err != nil {
+				return JSONRecord{}, err
+			}
+		}
+
+		row, err := r.reader.Read()
+		if err == io.EOF {
+			r.file.Close()
+			r.file, r.reader = nil, nil
+			r.fileIndex++
+			continue
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				r.line = parseErr.StartLine
+				return JSONRecord{}, &ValidationError{
+					Field:   r.Location(),
+					Message: fmt.Sprintf("invalid row: %v", parseErr.Err),
+				}
+			}
+			return JSONRecord{}, fmt.Errorf("reading %s: %w", r.Location(), err)
+		}
+		r.line, _ = r.reader.FieldPos(0)
+
+		data, err := r.recordData(row)
+		if err != nil {
+			return JSONRecord{}, &ValidationError{
+				Field:   r.Location(),
+				Message: err.Error(),
+			}
+		}
+		// Blocks without any data carry nothing worth inserting
+		if len(data) == 0 {
+			continue
+		}
+		return JSONRecord{Network: csvCell(row, r.header, "network"), Data: data}, nil
+	}
+}
+
+func (r *maxmindCSVReader) openBlocks(file string) error {
+	f, err := openInput(file)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	reader := csv.NewReader(f)
+	reader.ReuseRecord = true
+	header, err := readCSVHeader(reader)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("reading %s: %w", filepath.Base(file), err)
+	}
+	if _, ok := header["network"]; !ok {
+		f.Close()
+		return fmt.Errorf("reading %s: no network column", filepath.Base(file))
+	}
+	r.file, r.reader, r.header, r.line = f, reader, header, 1
+	return nil
+}
+
+// recordData builds the GeoIP2 record of a blocks row. Integers are typed
+// like in MaxMind databases, e.g. geoname_id is always an uint32.
+func (r *maxmindCSVReader) recordData(row []string) (map[string]any, error) {
+	get := func(column string) string {
+		return csvCell(row, r.header, column)
+	}
+	data := map[string]any{}
+
+	if asn := get("autonomous_system_number"); asn != "" {
+		data["autonomous_system_number"] = typedNumber("uint32", asn)
+	}
+	if org := get("autonomous_system_organization"); org != "" {
+		data["autonomous_system_organization"] = org
+	}
+
+	if id := get("geoname_id"); id != "" {
+		loc, err := r.lookupLocation(id, "geoname_id")
+		if err != nil {
+			return nil, err
+		}
+		if loc.continentCode != "" {
+			continent := map[string]any{"code": loc.continentCode}
+			setNames(continent, loc.continentNames)
+			data["continent"] = continent
+		}
+		if loc.countryISOCode != "" {
+			data["country"] = r.country(r.countries[loc.countryISOCode], loc)
+		}
+		var subdivisions []any
+		for _, subdivision := range []struct {
+			code  string
+			names map[string]any
+		}{
+			{loc.subdivision1Code, loc.subdivision1Names},
+			{loc.subdivision2Code, loc.subdivision2Names},
+		} {
+			if subdivision.code == "" && subdivision.names == nil {
+				continue
+			}
+			entry := map[string]any{}
+			if subdivision.code != "" {
+				entry["iso_code"] = subdivision.code
+			}
+			setNames(entry, subdivision.names)
+			subdivisions = append(subdivisions, entry)
+		}
+		if len(subdivisions) > 0 {
+			data["subdivisions"] = subdivisions
+		}
+		if loc.cityNames != nil {
+			city := map[string]any{"geoname_id": typedNumber("uint32", id)}
+			setNames(city, loc.cityNames)
+			data["city"] = city
+		}
+
+		location := map[string]any{}
+		if loc.metroCode != "" {
+			location["metro_code"] = typedNumber("uint16", loc.metroCode)
+		}
+		if loc.timeZone != "" {
+			location["time_zone"] = loc.timeZone
+		}
+		if len(location) > 0 {
+			data["location"] = location
+		}
+	}
+
+	for _, key := range []string{"registered_country", "represented_country"} {
+		id := get(key + "_geoname_id")
+		if id == "" {
+			continue
+		}
+		loc, err := r.lookupLocation(id, key+"_geoname_id")
+		if err != nil {
+			return nil, err
+		}
+		data[key] = r.country(id, loc)
+	}
+
+	location, _ := data["location"].(map[string]any)
+	if location == nil {
+		location = map[string]any{}
+	}
+	for column, mmdbType := range map[string]string{
+		"latitude":        "double",
+		"longitude":       "double",
+		"accuracy_radius": "uint16",
+	} {
+		if value := get(column); value != "" {
+			location[column] = typedNumber(mmdbType, value)
+		}
+	}
+	if len(location) > 0 {
+		data["location"] = location
+	}
+
+	if code := get("postal_code"); code != "" {
+		data["postal"] = map[string]any{"code": code}
+	}
+
+	traits := map[string]any{}
+	for _, flag := range []string{"is_anonymous_proxy", "is_satellite_provider", "is_anycast"} {
+		if get(flag) == "1" {
+			traits[flag] = true
+		}
+	}
+	if len(traits) > 0 {
+		data["traits"] = traits
+	}
+
+	return data, nil
+}
+
+func (r *maxmindCSVReader) lookupLocation(id string, column string) (*maxmindLocation, error) {
+	loc, ok := r.locations[id]
+	if !ok {
+		return nil, fmt.Errorf("%s %s not found in the locations files", column, id)
+	}
+	return loc, nil
+}
+
+// country builds a country object from the location of a country
+func (r *maxmindCSVReader) country(id string, loc *maxmindLocation) map[string]any {
+	country := map[string]any{}
+	if id != "" {
+		country["geoname_id"] = typedNumber("uint32", id)
+	}
+	if loc.countryISOCode != "" {
+		country["iso_code"] = loc.countryISOCode
+	}
+	if loc.inEuropeanUnion {
+		country["is_in_european_union"] = true
+	}
+	setNames(country, loc.countryNames)
+	return country
+}
+
+func (r *maxmindCSVReader) Location() string {
+	if r.fileIndex >= len(r.blocks) {
+		return ""
+	}
+	return fmt.Sprintf("%s line %d", filepath.Base(r.blocks[r.fileIndex]), r.line)
+}
+
+func (r *maxmindCSVReader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
+
+func setNames(m map[string]any, names map[string]any) {
+	if names != nil {
+		m["names"] = names
+	}
+}
+
+func typedNumber(mmdbType string, value string) map[string]any {
+	return map[string]any{typeKey: mmdbType, "value": value}
+}
+
+// readCSVHeader reads the header row and returns the index of every column
+func readCSVHeader(reader *csv.Reader) (map[string]int, error) {
+	row, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("file has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	header := make(map[string]int, len(row))
+	for i, name := range row {
+		header[strings.TrimSpace(name)] = i
+	}
+	return header, nil
+}
+
+// csvCell returns the trimmed cell of a column, or "" when the file has no
+// such column
+func csvCell(row []string, header map[string]int, column string) string {
+	i, ok := header[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMaxMindCSVCountryAcrossLocales(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"GeoLite2-City-Blocks-IPv4.csv": "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius\n" +
+			"1.0.0.0/24,9999,2921044,,0,0,,,,\n" +
+			"1.0.1.0/24,2921044,2921044,,0,0,,,,\n",
+		"GeoLite2-City-Locations-en.csv": "geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union\n" +
+			"2921044,en,EU,Europe,DE,Germany,,,,,,,,1\n" +
+			"9999,en,EU,Europe,DE,Germany,,,,,Smalltown,,Europe/Berlin,1\n",
+		// Cities without a Japanese name have an empty city_name here
+		"GeoLite2-City-Locations-ja.csv": "geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union\n" +
+			"2921044,ja,EU,ヨーロッパ,DE,ドイツ連邦共和国,,,,,,,,1\n" +
+			"9999,ja,EU,ヨーロッパ,DE,ドイツ連邦共和国,,,,,,,Europe/Berlin,1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader, err := newMaxMindCSVReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if got := reader.Metadata().Languages; len(got) != 2 || got[0] != "en" || got[1] != "ja" {
+		t.Errorf("languages = %v, want [en ja]", got)
+	}
+	metadata, err := readMaxMindMetadata(filepath.Join(dir, "GeoLite2-City-Blocks-IPv4.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(metadata, reader.Metadata()) {
+		t.Errorf("metadata from file names = %+v, want %+v", metadata, reader.Metadata())
+	}
+
+	tests := []struct {
+		network string
+		city    bool
+	}{
+		{"1.0.0.0/24", true},
+		{"1.0.1.0/24", false},
+	}
+	for _, tt := range tests {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Network != tt.network {
+			t.Fatalf("network = %s, want %s", record.Network, tt.network)
+		}
+
+		country := record.Data["country"].(map[string]any)
+		if id := country["geoname_id"].(map[string]any)["value"]; id != "2921044" {
+			t.Errorf("%s: country.geoname_id = %v, want 2921044", tt.network, id)
+		}
+		names := country["names"].(map[string]any)
+		if names["en"] != "Germany" || names["ja"] != "ドイツ連邦共和国" {
+			t.Errorf("%s: country.names = %v", tt.network, names)
+		}
+
+		city, ok := record.Data["city"].(map[string]any)
+		if ok != tt.city {
+			t.Fatalf("%s: city = %v, want city %v", tt.network, record.Data["city"], tt.city)
+		}
+		if ok {
+			if names := city["names"].(map[string]any); len(names) != 1 || names["en"] != "Smalltown" {
+				t.Errorf("%s: city.names = %v, want only en", tt.network, names)
+			}
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
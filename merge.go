@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// mergeStrategies maps the --merge names to the inserter used when a record
+// overlaps networks inserted by earlier records.
+var mergeStrategies = map[string]inserter.FuncGenerator{
+	// replace the earlier data entirely (mmdbwriter's default)
+	"replace": inserter.ReplaceWith,
+	// add the top-level keys of the new data to the earlier data
+	"top-level": inserter.TopLevelMergeWith,
+	// recursively merge maps and slices into the earlier data
+	"deep": inserter.DeepMergeWith,
+	// leave networks that already have data untouched
+	"keep-existing": keepExistingWith,
+	// collect the values of a key into an array
+	"append": appendWith,
+}
+
+// keepExistingWith generates an inserter function that only fills networks
+// which have no data yet.
+func keepExistingWith(value mmdbtype.DataType) inserter.Func {
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if existingValue != nil {
+			return existingValue, nil
+		}
+		return value, nil
+	}
+}
+
+// appendWith generates an inserter function that accumulates values. Keys
+// only one side has are kept, maps are merged recursively and the values of
+// a key are collected into an array, without duplicates. Values are always
+// written as arrays, also when a network gets only one of them.
+func appendWith(value mmdbtype.DataType) inserter.Func {
+	value = arrayValues(value)
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		return appendValues(existingValue, value), nil
+	}
+}
+
+// arrayValues wraps the values of a map that are neither maps nor arrays
+// into an array of one value
+func arrayValues(value mmdbtype.DataType) mmdbtype.DataType {
+	switch v := value.(type) {
+	case nil, mmdbtype.Slice:
+		return v
+	case mmdbtype.Map:
+		wrapped := make(mmdbtype.Map, len(v))
+		for key, item := range v {
+			wrapped[key] = arrayValues(item)
+		}
+		return wrapped
+	default:
+		return mmdbtype.Slice{v}
+	}
+}
+
+func appendValues(existing, value mmdbtype.DataType) mmdbtype.DataType {
+	if existing == nil {
+		return value
+	}
+	if value == nil {
+		return existing
+	}
+
+	existingMap, ok := existing.(mmdbtype.Map)
+	if newMap, isMap := value.(mmdbtype.Map); ok && isMap {
+		merged := existingMap.Copy().(mmdbtype.Map)
+		for key, v := range newMap {
+			merged[key] = appendValues(merged[key], v)
+		}
+		return merged
+	}
+	if existing.Equal(value) {
+		return existing
+	}
+
+	var merged mmdbtype.Slice
+	for _, v := range []mmdbtype.DataType{existing, value} {
+		items, ok := v.(mmdbtype.Slice)
+		if !ok {
+			items = mmdbtype.Slice{v}
+		}
+	items:
+		for _, item := range items {
+			for _, m := range merged {
+				if m.Equal(item) {
+					continue items
+				}
+			}
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// mergeStrategyNames returns the names accepted by --merge, sorted
+func mergeStrategyNames() []string {
+	names := make([]string, 0, len(mergeStrategies))
+	for name := range mergeStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupMergeStrategy(name string) (inserter.FuncGenerator, error) {
+	strategy, ok := mergeStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown merge strategy %q, expected one of: %s", name, joinStrings(mergeStrategyNames()))
+	}
+	return strategy, nil
+}
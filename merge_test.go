@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestKeepExistingWith(t *testing.T) {
+	value := mmdbtype.Map{"a": mmdbtype.String("new")}
+
+	got, err := keepExistingWith(value)(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(value) {
+		t.Errorf("empty network: got %#v, want %#v", got, value)
+	}
+
+	existing := mmdbtype.Map{"a": mmdbtype.String("old")}
+	got, err = keepExistingWith(value)(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(existing) {
+		t.Errorf("filled network: got %#v, want %#v", got, existing)
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		inner    mmdbtype.DataType
+		outer    mmdbtype.DataType
+	}{
+		{
+			strategy: "replace",
+			inner:    mmdbtype.Map{"b": mmdbtype.String("y")},
+			outer:    mmdbtype.Map{"a": mmdbtype.String("x")},
+		},
+		{
+			strategy: "top-level",
+			inner:    mmdbtype.Map{"a": mmdbtype.String("x"), "b": mmdbtype.String("y")},
+			outer:    mmdbtype.Map{"a": mmdbtype.String("x")},
+		},
+		{
+			strategy: "keep-existing",
+			inner:    mmdbtype.Map{"a": mmdbtype.String("x")},
+			outer:    mmdbtype.Map{"a": mmdbtype.String("x")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, err := lookupMergeStrategy(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree := newTestTree(t, mmdbwriter.Options{IncludeReservedNetworks: true}, nil)
+			_, outer, _ := net.ParseCIDR("1.0.0.0/16")
+			_, inner, _ := net.ParseCIDR("1.0.1.0/24")
+			if err := tree.InsertFunc(outer, strategy(mmdbtype.Map{"a": mmdbtype.String("x")})); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.InsertFunc(inner, strategy(mmdbtype.Map{"b": mmdbtype.String("y")})); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, got := tree.Get(net.ParseIP("1.0.1.1")); !got.Equal(tt.inner) {
+				t.Errorf("inner network: got %#v, want %#v", got, tt.inner)
+			}
+			if _, got := tree.Get(net.ParseIP("1.0.2.1")); !got.Equal(tt.outer) {
+				t.Errorf("outer network: got %#v, want %#v", got, tt.outer)
+			}
+		})
+	}
+
+	if _, err := lookupMergeStrategy("first"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestAppendValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing mmdbtype.DataType
+		value    mmdbtype.DataType
+		want     mmdbtype.DataType
+	}{
+		{"nil existing", nil, mmdbtype.String("a"), mmdbtype.String("a")},
+		{"nil value", mmdbtype.String("a"), nil, mmdbtype.String("a")},
+		{"equal values", mmdbtype.String("a"), mmdbtype.String("a"), mmdbtype.String("a")},
+		{"different values", mmdbtype.String("a"), mmdbtype.String("b"), mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")}},
+		{
+			"array and value",
+			mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+			mmdbtype.String("b"),
+			mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+		},
+		{
+			"arrays without duplicates",
+			mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+			mmdbtype.Slice{mmdbtype.String("b"), mmdbtype.String("c")},
+			mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b"), mmdbtype.String("c")},
+		},
+		{
+			"maps merged by key",
+			mmdbtype.Map{"a": mmdbtype.String("x"), "b": mmdbtype.Map{"c": mmdbtype.Uint16(1)}},
+			mmdbtype.Map{"b": mmdbtype.Map{"c": mmdbtype.Uint16(2)}, "d": mmdbtype.Bool(true)},
+			mmdbtype.Map{
+				"a": mmdbtype.String("x"),
+				"b": mmdbtype.Map{"c": mmdbtype.Slice{mmdbtype.Uint16(1), mmdbtype.Uint16(2)}},
+				"d": mmdbtype.Bool(true),
+			},
+		},
+		{"map and value", mmdbtype.Map{"a": mmdbtype.String("x")}, mmdbtype.String("y"), mmdbtype.Slice{mmdbtype.Map{"a": mmdbtype.String("x")}, mmdbtype.String("y")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appendValues(tt.existing, tt.value)
+			if !got.Equal(tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendWithWritesArrays(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing mmdbtype.DataType
+		value    mmdbtype.DataType
+		want     mmdbtype.DataType
+	}{
+		{
+			"empty network",
+			nil,
+			mmdbtype.Map{"blocklist": mmdbtype.String("tor-exit")},
+			mmdbtype.Map{"blocklist": mmdbtype.Slice{mmdbtype.String("tor-exit")}},
+		},
+		{
+			"network with a plain value",
+			mmdbtype.Map{"blocklist": mmdbtype.String("spamhaus-drop")},
+			mmdbtype.Map{"blocklist": mmdbtype.String("tor-exit")},
+			mmdbtype.Map{"blocklist": mmdbtype.Slice{mmdbtype.String("spamhaus-drop"), mmdbtype.String("tor-exit")}},
+		},
+		{
+			"same value twice",
+			mmdbtype.Map{"blocklist": mmdbtype.Slice{mmdbtype.String("tor-exit")}},
+			mmdbtype.Map{"blocklist": mmdbtype.String("tor-exit")},
+			mmdbtype.Map{"blocklist": mmdbtype.Slice{mmdbtype.String("tor-exit")}},
+		},
+		{
+			"nested maps",
+			nil,
+			mmdbtype.Map{"source": mmdbtype.Map{"name": mmdbtype.String("drop"), "ids": mmdbtype.Slice{mmdbtype.Uint16(1)}}},
+			mmdbtype.Map{"source": mmdbtype.Map{"name": mmdbtype.Slice{mmdbtype.String("drop")}, "ids": mmdbtype.Slice{mmdbtype.Uint16(1)}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appendWith(tt.value)(tt.existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataFields are the metadata keys an input may set. An MMDB file has no
+// room for other keys, so they are rejected instead of being dropped.
+var metadataFields = []string{
+	"database_type",
+	"description",
+	"languages",
+	"build_epoch",
+	"ip_version",
+	"record_size",
+	"binary_format_major_version",
+	"binary_format_minor_version",
+}
+
+// UnmarshalJSON decodes metadata, rejecting keys that cannot be written
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for key := range fields {
+		if !containsString(metadataFields, key) {
+			return fmt.Errorf("unknown metadata field %q, expected one of: %s", key, joinStrings(metadataFields))
+		}
+	}
+
+	type metadataAlias Metadata
+	return json.Unmarshal(data, (*metadataAlias)(m))
+}
+
+// validateMetadataFormat checks the metadata fields that configure the
+// writer rather than describe the database
+func validateMetadataFormat(m Metadata, ve *ValidationErrors) {
+	if m.IPVersion != nil && *m.IPVersion != 4 && *m.IPVersion != 6 {
+		ve.Add("metadata.ip_version", fmt.Sprintf("unsupported IP version %d, expected 4 or 6", *m.IPVersion))
+	}
+	if m.RecordSize != nil {
+		switch *m.RecordSize {
+		case 24, 28, 32:
+		default:
+			ve.Add("metadata.record_size", fmt.Sprintf("unsupported record size %d, expected 24, 28 or 32", *m.RecordSize))
+		}
+	}
+	if m.BinaryFormatMajorVersion != nil && *m.BinaryFormatMajorVersion != 2 {
+		ve.Add("metadata.binary_format_major_version",
+			fmt.Sprintf("binary format version %d cannot be written, only version 2 is supported", *m.BinaryFormatMajorVersion))
+	}
+	if m.BinaryFormatMinorVersion != nil && *m.BinaryFormatMinorVersion != 0 {
+		ve.Add("metadata.binary_format_minor_version",
+			fmt.Sprintf("binary format minor version %d cannot be written, only version 2.0 is supported", *m.BinaryFormatMinorVersion))
+	}
+}
+
+// defaultBuildEpoch is the build timestamp of a database whose metadata has
+// no build_epoch: SOURCE_DATE_EPOCH when it is set, so builds can be
+// reproduced, otherwise the current time.
+func defaultBuildEpoch() (int64, error) {
+	value, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || value == "" {
+		return time.Now().Unix(), nil
+	}
+	epoch, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || epoch < 0 {
+		return 0, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, expected a Unix timestamp", value)
+	}
+	return epoch, nil
+}
+
+// isJSONArray reports whether data holds a JSON array at the top level
+func isJSONArray(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+}
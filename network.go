@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"go4.org/netipx"
+)
+
+// recordRange returns the addresses a record covers. The network of a record
+// can be a CIDR, a single address (a /32 or /128) or a "start-end" range, or
+// the record gives start_ip and end_ip instead. Errors are *ValidationError
+// with the field relative to the record.
+func recordRange(record JSONRecord) (netipx.IPRange, error) {
+	hasRange := record.StartIP != "" || record.EndIP != ""
+	switch {
+	case record.Network != "" && hasRange:
+		return netipx.IPRange{}, &ValidationError{
+			Field:   "network",
+			Message: "use either network or start_ip and end_ip",
+		}
+	case hasRange:
+		if record.StartIP == "" || record.EndIP == "" {
+			field := "start_ip"
+			if record.EndIP == "" {
+				field = "end_ip"
+			}
+			return netipx.IPRange{}, &ValidationError{
+				Field:   field,
+				Message: "start_ip and end_ip must both be set",
+			}
+		}
+		return parseIPRange(record.StartIP, record.EndIP, "start_ip", "end_ip")
+	case record.Network == "":
+		return netipx.IPRange{}, &ValidationError{
+			Field:   "network",
+			Message: "network is required",
+		}
+	}
+
+	network := strings.TrimSpace(record.Network)
+	if start, end, ok := strings.Cut(network, "-"); ok {
+		return parseIPRange(start, end, "network", "network")
+	}
+
+	if strings.Contains(network, "/") {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return netipx.IPRange{}, &ValidationError{
+				Field:   "network",
+				Message: fmt.Sprintf("invalid CIDR format: %v", err),
+			}
+		}
+		return netipx.RangeOfPrefix(prefix.Masked()), nil
+	}
+
+	addr, err := netip.ParseAddr(network)
+	if err != nil {
+		return netipx.IPRange{}, &ValidationError{
+			Field:   "network",
+			Message: fmt.Sprintf("invalid network, expected a CIDR, an IP address or a range: %v", err),
+		}
+	}
+	return netipx.IPRangeFrom(addr, addr), nil
+}
+
+// parseIPRange parses the first and last address of a range
+func parseIPRange(start, end string, startField, endField string) (netipx.IPRange, error) {
+	from, err := netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil {
+		return netipx.IPRange{}, &ValidationError{
+			Field:   startField,
+			Message: fmt.Sprintf("invalid range start: %v", err),
+		}
+	}
+	to, err := netip.ParseAddr(strings.TrimSpace(end))
+	if err != nil {
+		return netipx.IPRange{}, &ValidationError{
+			Field:   endField,
+			Message: fmt.Sprintf("invalid range end: %v", err),
+		}
+	}
+
+	if from.Is4() != to.Is4() {
+		return netipx.IPRange{}, &ValidationError{
+			Field:   startField,
+			Message: fmt.Sprintf("range %s-%s mixes IPv4 and IPv6", from, to),
+		}
+	}
+	if from.Compare(to) > 0 {
+		return netipx.IPRange{}, &ValidationError{
+			Field:   startField,
+			Message: fmt.Sprintf("range %s-%s is inverted, start is after end", from, to),
+		}
+	}
+	return netipx.IPRangeFrom(from, to), nil
+}
+
+// insertRange inserts a range into the tree, as a single network when the
+// range is a CIDR, otherwise split into the minimal set of CIDRs.
+func insertRange(writer *mmdbwriter.Tree, r netipx.IPRange, insert inserter.Func) error {
+	if prefix, ok := r.Prefix(); ok {
+		return writer.InsertFunc(netipx.PrefixIPNet(prefix), insert)
+	}
+	return writer.InsertRangeFunc(net.IP(r.From().AsSlice()), net.IP(r.To().AsSlice()), insert)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestRecordRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   JSONRecord
+		prefixes []string
+		errField string
+	}{
+		{"cidr", JSONRecord{Network: "1.0.0.0/24"}, []string{"1.0.0.0/24"}, ""},
+		{"cidr with host bits", JSONRecord{Network: "1.0.0.7/24"}, []string{"1.0.0.0/24"}, ""},
+		{"ipv4 address", JSONRecord{Network: "1.2.3.4"}, []string{"1.2.3.4/32"}, ""},
+		{"ipv6 address", JSONRecord{Network: "2001:db8::1"}, []string{"2001:db8::1/128"}, ""},
+		{"range", JSONRecord{Network: "1.0.0.0 - 1.0.2.255"}, []string{"1.0.0.0/23", "1.0.2.0/24"}, ""},
+		{"unaligned range", JSONRecord{Network: "1.0.0.1-1.0.0.6"}, []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}, ""},
+		{"range that is a cidr", JSONRecord{Network: "1.0.0.0-1.0.0.255"}, []string{"1.0.0.0/24"}, ""},
+		{"start_ip and end_ip", JSONRecord{StartIP: "2001:db8::", EndIP: "2001:db8::ffff"}, []string{"2001:db8::/112"}, ""},
+		{"network and start_ip", JSONRecord{Network: "1.0.0.0/24", StartIP: "1.0.0.0", EndIP: "1.0.0.255"}, nil, "network"},
+		{"missing end_ip", JSONRecord{StartIP: "1.0.0.0"}, nil, "end_ip"},
+		{"missing start_ip", JSONRecord{EndIP: "1.0.0.0"}, nil, "start_ip"},
+		{"missing network", JSONRecord{}, nil, "network"},
+		{"invalid cidr", JSONRecord{Network: "1.0.0.0/33"}, nil, "network"},
+		{"invalid address", JSONRecord{Network: "1.0.0"}, nil, "network"},
+		{"invalid end", JSONRecord{StartIP: "1.0.0.0", EndIP: "x"}, nil, "end_ip"},
+		{"mixed versions", JSONRecord{Network: "1.0.0.0-::1"}, nil, "network"},
+		{"inverted range", JSONRecord{StartIP: "1.0.0.9", EndIP: "1.0.0.1"}, nil, "start_ip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := recordRange(tt.record)
+			if tt.errField != "" {
+				recordErr, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("expected a ValidationError, got %v", err)
+				}
+				if recordErr.Field != tt.errField {
+					t.Errorf("field = %q, want %q", recordErr.Field, tt.errField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var prefixes []string
+			for _, prefix := range r.Prefixes() {
+				prefixes = append(prefixes, prefix.String())
+			}
+			if !slices.Equal(prefixes, tt.prefixes) {
+				t.Errorf("prefixes = %v, want %v", prefixes, tt.prefixes)
+			}
+		})
+	}
+}
+
+func TestInsertRange(t *testing.T) {
+	tree := newTestTree(t, mmdbwriter.Options{IPVersion: 4, RecordSize: 24}, nil)
+	r, err := recordRange(JSONRecord{Network: "1.0.0.1-1.0.0.6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := mmdbtype.Map{"a": mmdbtype.String("x")}
+	if err := insertRange(tree, r, keepExistingWith(value)); err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, want := range map[string]bool{
+		"1.0.0.0": false,
+		"1.0.0.1": true,
+		"1.0.0.6": true,
+		"1.0.0.7": false,
+	} {
+		_, got := tree.Get(net.ParseIP(ip).To4())
+		if (got != nil) != want {
+			t.Errorf("%s: got %#v, want data %v", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// NumberPolicy decides which MMDB types JSON numbers are written as.
+type NumberPolicy struct {
+	// Integer is the MMDB type for integer values, or "auto" to use the
+	// smallest unsigned type that fits (int32 for negative values).
+	Integer string
+	// Float is the MMDB type for fractional values, "double" or "float".
+	Float string
+}
+
+// numberPolicy is set from the command line flags in main, buildDatabase
+// sets the policy of its build while it runs
+var numberPolicy = NumberPolicy{Integer: "auto", Float: "double"}
+
+// integerTypes and floatTypes are the accepted NumberPolicy types
+var (
+	integerTypes = []string{"auto", "uint16", "uint32", "uint64", "uint128", "int32", "double", "float"}
+	floatTypes   = []string{"double", "float"}
+)
+
+// Bounds of the MMDB integer types
+var (
+	maxUint16  = big.NewInt(math.MaxUint16)
+	maxUint32  = big.NewInt(math.MaxUint32)
+	maxUint64  = new(big.Int).SetUint64(math.MaxUint64)
+	maxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	minInt32   = big.NewInt(math.MinInt32)
+	maxInt32   = big.NewInt(math.MaxInt32)
+)
+
+// isIntegerLiteral reports whether a JSON number has no fraction or exponent
+func isIntegerLiteral(s string) bool {
+	return !strings.ContainsAny(s, ".eE")
+}
+
+// convertNumber converts a JSON number according to numberPolicy
+func convertNumber(n json.Number) (mmdbtype.DataType, error) {
+	s := n.String()
+	if isIntegerLiteral(s) {
+		return convertInteger(s, numberPolicy.Integer)
+	}
+	return convertFloat(s, numberPolicy.Float)
+}
+
+// convertInteger parses a decimal integer into the given MMDB type, or the
+// smallest fitting type when mmdbType is "auto".
+func convertInteger(s string, mmdbType string) (mmdbtype.DataType, error) {
+	value, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer: %s", s)
+	}
+
+	if mmdbType == "auto" {
+		mmdbType = smallestIntegerType(value)
+		if mmdbType == "" {
+			return nil, fmt.Errorf("integer %s is out of range for MMDB integer types", s)
+		}
+	}
+
+	switch mmdbType {
+	case "uint16", "uint32", "uint64", "uint128", "int32":
+		if !integerFits(value, mmdbType) {
+			return nil, fmt.Errorf("integer %s is out of range for %s", s, mmdbType)
+		}
+	}
+
+	switch mmdbType {
+	case "uint16":
+		return mmdbtype.Uint16(value.Uint64()), nil
+	case "uint32":
+		return mmdbtype.Uint32(value.Uint64()), nil
+	case "uint64":
+		return mmdbtype.Uint64(value.Uint64()), nil
+	case "uint128":
+		return (*mmdbtype.Uint128)(value), nil
+	case "int32":
+		return mmdbtype.Int32(value.Int64()), nil
+	case "double", "float":
+		return convertFloat(s, mmdbType)
+	default:
+		return nil, fmt.Errorf("unsupported integer type: %s", mmdbType)
+	}
+}
+
+// convertFloat parses a number into an MMDB double or float
+func convertFloat(s string, mmdbType string) (mmdbtype.DataType, error) {
+	switch mmdbType {
+	case "double":
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double %s: %w", s, err)
+		}
+		return mmdbtype.Float64(value), nil
+	case "float":
+		value, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s: %w", s, err)
+		}
+		return mmdbtype.Float32(value), nil
+	default:
+		return nil, fmt.Errorf("unsupported float type: %s", mmdbType)
+	}
+}
+
+// smallestIntegerType returns the smallest MMDB type able to hold value, or
+// an empty string when no type can.
+func smallestIntegerType(value *big.Int) string {
+	for _, mmdbType := range []string{"uint16", "uint32", "uint64", "uint128", "int32"} {
+		if integerFits(value, mmdbType) {
+			return mmdbType
+		}
+	}
+	return ""
+}
+
+func integerFits(value *big.Int, mmdbType string) bool {
+	if mmdbType == "int32" {
+		return value.Cmp(minInt32) >= 0 && value.Cmp(maxInt32) <= 0
+	}
+	if value.Sign() < 0 {
+		return false
+	}
+
+	switch mmdbType {
+	case "uint16":
+		return value.Cmp(maxUint16) <= 0
+	case "uint32":
+		return value.Cmp(maxUint32) <= 0
+	case "uint64":
+		return value.Cmp(maxUint64) <= 0
+	case "uint128":
+		return value.Cmp(maxUint128) <= 0
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestConvertInteger(t *testing.T) {
+	uint128Max, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+	uint64Above, _ := new(big.Int).SetString("18446744073709551616", 10)
+
+	tests := []struct {
+		value    string
+		mmdbType string
+		want     mmdbtype.DataType
+		wantErr  bool
+	}{
+		{"0", "auto", mmdbtype.Uint16(0), false},
+		{"65535", "auto", mmdbtype.Uint16(65535), false},
+		{"65536", "auto", mmdbtype.Uint32(65536), false},
+		{"4294967295", "auto", mmdbtype.Uint32(4294967295), false},
+		{"4294967296", "auto", mmdbtype.Uint64(4294967296), false},
+		{"18446744073709551615", "auto", mmdbtype.Uint64(18446744073709551615), false},
+		{"18446744073709551616", "auto", (*mmdbtype.Uint128)(uint64Above), false},
+		{"340282366920938463463374607431768211455", "auto", (*mmdbtype.Uint128)(uint128Max), false},
+		{"340282366920938463463374607431768211456", "auto", nil, true},
+		{"-1", "auto", mmdbtype.Int32(-1), false},
+		{"-2147483648", "auto", mmdbtype.Int32(-2147483648), false},
+		{"-2147483649", "auto", nil, true},
+		{"7", "uint32", mmdbtype.Uint32(7), false},
+		{"65536", "uint16", nil, true},
+		{"-1", "uint64", nil, true},
+		{"2147483648", "int32", nil, true},
+		{"12", "double", mmdbtype.Float64(12), false},
+		{"12", "float", mmdbtype.Float32(12), false},
+		{"12", "string", nil, true},
+		{"1x", "auto", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+"/"+tt.mmdbType, func(t *testing.T) {
+			got, err := convertInteger(tt.value, tt.mmdbType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertNumberPolicy(t *testing.T) {
+	defer func(policy NumberPolicy) { numberPolicy = policy }(numberPolicy)
+
+	tests := []struct {
+		policy NumberPolicy
+		value  string
+		want   mmdbtype.DataType
+	}{
+		{NumberPolicy{Integer: "auto", Float: "double"}, "42", mmdbtype.Uint16(42)},
+		{NumberPolicy{Integer: "auto", Float: "double"}, "4.5", mmdbtype.Float64(4.5)},
+		{NumberPolicy{Integer: "auto", Float: "float"}, "4.5", mmdbtype.Float32(4.5)},
+		{NumberPolicy{Integer: "auto", Float: "double"}, "1e3", mmdbtype.Float64(1000)},
+		{NumberPolicy{Integer: "uint64", Float: "double"}, "42", mmdbtype.Uint64(42)},
+		{NumberPolicy{Integer: "double", Float: "double"}, "42", mmdbtype.Float64(42)},
+	}
+
+	for _, tt := range tests {
+		numberPolicy = tt.policy
+		got, err := convertNumber(json.Number(tt.value))
+		if err != nil {
+			t.Fatalf("%s with %+v: %v", tt.value, tt.policy, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s with %+v: got %#v, want %#v", tt.value, tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sort"
+
+	"go4.org/netipx"
+)
+
+// OverlapIndex collects the networks of all records so overlapping records
+// can be found once the whole input has been read. Only the network, the
+// record number and a hash of the data are kept per record, so the index
+// stays small for large inputs; locateOverlaps adds the locations of the
+// records that overlap afterwards.
+type OverlapIndex struct {
+	entries []overlapEntry
+}
+
+type overlapEntry struct {
+	network  netipx.IPRange
+	record   int
+	dataHash uint64
+}
+
+// Overlap describes two records whose networks overlap. Record is always the
+// earlier record, Other the later one.
+type Overlap struct {
+	// Kind is "duplicate" when both records have the same network,
+	// "shadowed" when the later record covers the whole earlier network and
+	// "partial" when the later record overrides part of the earlier network
+	// with different data.
+	Kind          string `json:"kind"`
+	Network       string `json:"network"`
+	Record        int    `json:"record"`
+	Location      string `json:"location"`
+	OtherNetwork  string `json:"other_network"`
+	OtherRecord   int    `json:"other_record"`
+	OtherLocation string `json:"other_location"`
+	SameData      bool   `json:"same_data"`
+}
+
+// OverlapReport summarizes the overlaps found in an input
+type OverlapReport struct {
+	Duplicates int       `json:"duplicates"`
+	Shadowed   int       `json:"shadowed"`
+	Partial    int       `json:"partial"`
+	Overlaps   []Overlap `json:"overlaps"`
+}
+
+// Total returns the number of overlaps found
+func (r OverlapReport) Total() int {
+	return r.Duplicates + r.Shadowed + r.Partial
+}
+
+// Add indexes the network of a record. index counts the records of all
+// inputs, including records that failed to parse. Records with an invalid
+// network are skipped, they are reported by the validation.
+func (idx *OverlapIndex) Add(record JSONRecord, index int) {
+	network, err := recordRange(record)
+	if err != nil {
+		return
+	}
+	idx.entries = append(idx.entries, overlapEntry{
+		network:  network,
+		record:   index,
+		dataHash: hashRecordData(record.Data),
+	})
+}
+
+// hashRecordData hashes the canonical JSON encoding of record data, which has
+// its map keys sorted.
+func hashRecordData(data map[string]any) uint64 {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(encoded)
+	return h.Sum64()
+}
+
+// Report finds all pairs of overlapping records, without their locations.
+// Networks are sorted by their first address, larger networks first, so each
+// network is only compared against the earlier networks still open at its
+// first address. Every pair of records is reported once.
+func (idx *OverlapIndex) Report() OverlapReport {
+	sort.Slice(idx.entries, func(i, j int) bool {
+		a, b := idx.entries[i], idx.entries[j]
+		if c := a.network.From().Compare(b.network.From()); c != 0 {
+			return c < 0
+		}
+		if c := a.network.To().Compare(b.network.To()); c != 0 {
+			return c > 0
+		}
+		return a.record < b.record
+	})
+
+	report := OverlapReport{Overlaps: []Overlap{}}
+	var open []overlapEntry
+	for _, entry := range idx.entries {
+		// Networks ending before this one starts cannot overlap it or any
+		// later network
+		kept := open[:0]
+		for _, other := range open {
+			if other.network.To().Compare(entry.network.From()) >= 0 {
+				kept = append(kept, other)
+			}
+		}
+		open = kept
+
+		for _, other := range open {
+			earlier, later := other, entry
+			if later.record < earlier.record {
+				earlier, later = later, earlier
+			}
+
+			overlap := Overlap{
+				Network:      rangeString(earlier.network),
+				Record:       earlier.record,
+				OtherNetwork: rangeString(later.network),
+				OtherRecord:  later.record,
+				SameData:     earlier.dataHash == later.dataHash,
+			}
+
+			switch {
+			case earlier.network == later.network:
+				overlap.Kind = "duplicate"
+				report.Duplicates++
+			case rangeContains(later.network, earlier.network):
+				overlap.Kind = "shadowed"
+				report.Shadowed++
+			case !overlap.SameData:
+				overlap.Kind = "partial"
+				report.Partial++
+			default:
+				// A later record overriding part of an earlier network with
+				// the same data changes nothing
+				continue
+			}
+			report.Overlaps = append(report.Overlaps, overlap)
+		}
+
+		open = append(open, entry)
+	}
+
+	sort.SliceStable(report.Overlaps, func(i, j int) bool {
+		if report.Overlaps[i].Record != report.Overlaps[j].Record {
+			return report.Overlaps[i].Record < report.Overlaps[j].Record
+		}
+		return report.Overlaps[i].OtherRecord < report.Overlaps[j].OtherRecord
+	})
+
+	return report
+}
+
+// rangeContains reports whether outer contains all of inner
+func rangeContains(outer, inner netipx.IPRange) bool {
+	return outer.From().Compare(inner.From()) <= 0 && outer.To().Compare(inner.To()) >= 0
+}
+
+// rangeString formats a range as a CIDR when it is one, as "start-end"
+// otherwise
+func rangeString(r netipx.IPRange) string {
+	if prefix, ok := r.Prefix(); ok {
+		return prefix.String()
+	}
+	return r.String()
+}
+
+// locateOverlaps reads the inputs again to fill in the locations of the
+// records in report. Records are numbered as scanInputFile numbers them.
+func locateOverlaps(paths []string, opts InputOptions, report *OverlapReport) error {
+	if len(report.Overlaps) == 0 {
+		return nil
+	}
+	locations := make(map[int]string)
+	for _, overlap := range report.Overlaps {
+		locations[overlap.Record] = ""
+		locations[overlap.OtherRecord] = ""
+	}
+
+	reader, err := openRecordReader(paths, opts)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for recordIndex := 0; ; recordIndex++ {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var recordErr *ValidationError
+			if errors.As(err, &recordErr) {
+				continue
+			}
+			return err
+		}
+		if _, ok := locations[recordIndex]; ok {
+			locations[recordIndex] = reader.Location()
+		}
+	}
+
+	for i := range report.Overlaps {
+		report.Overlaps[i].Location = locations[report.Overlaps[i].Record]
+		report.Overlaps[i].OtherLocation = locations[report.Overlaps[i].OtherRecord]
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOverlapReport(t *testing.T) {
+	a := map[string]any{"a": "1"}
+	b := map[string]any{"a": "2"}
+
+	tests := []struct {
+		name    string
+		records []JSONRecord
+		want    []Overlap
+	}{
+		{
+			name:    "disjoint networks",
+			records: []JSONRecord{{Network: "1.0.0.0/24", Data: a}, {Network: "1.0.1.0/24", Data: b}},
+			want:    nil,
+		},
+		{
+			name:    "duplicate",
+			records: []JSONRecord{{Network: "1.0.0.0/24", Data: a}, {Network: "1.0.0.0/24", Data: a}},
+			want:    []Overlap{{Kind: "duplicate", Network: "1.0.0.0/24", Record: 0, OtherNetwork: "1.0.0.0/24", OtherRecord: 1, SameData: true}},
+		},
+		{
+			name:    "later record shadows an earlier one",
+			records: []JSONRecord{{Network: "1.0.1.0/24", Data: a}, {Network: "1.0.0.0/16", Data: b}},
+			want:    []Overlap{{Kind: "shadowed", Network: "1.0.1.0/24", Record: 0, OtherNetwork: "1.0.0.0/16", OtherRecord: 1}},
+		},
+		{
+			name:    "later record partly overrides an earlier one",
+			records: []JSONRecord{{Network: "1.0.0.0/16", Data: a}, {Network: "1.0.1.0/24", Data: b}},
+			want:    []Overlap{{Kind: "partial", Network: "1.0.0.0/16", Record: 0, OtherNetwork: "1.0.1.0/24", OtherRecord: 1}},
+		},
+		{
+			name:    "more specific record with the same data",
+			records: []JSONRecord{{Network: "1.0.0.0/16", Data: a}, {Network: "1.0.1.0/24", Data: a}},
+			want:    nil,
+		},
+		{
+			name:    "network inside an earlier range",
+			records: []JSONRecord{{Network: "1.0.0.0-1.0.2.255", Data: a}, {Network: "1.0.2.0/24", Data: b}},
+			want:    []Overlap{{Kind: "partial", Network: "1.0.0.0-1.0.2.255", Record: 0, OtherNetwork: "1.0.2.0/24", OtherRecord: 1}},
+		},
+		{
+			name:    "range shadowed by a later network",
+			records: []JSONRecord{{Network: "1.0.0.1-1.0.0.6", Data: a}, {Network: "1.0.0.0/16", Data: b}},
+			want:    []Overlap{{Kind: "shadowed", Network: "1.0.0.1-1.0.0.6", Record: 0, OtherNetwork: "1.0.0.0/16", OtherRecord: 1}},
+		},
+		{
+			name:    "later network covering part of a range",
+			records: []JSONRecord{{Network: "1.0.0.0-1.0.2.255", Data: a}, {Network: "1.0.0.0/23", Data: b}},
+			want:    []Overlap{{Kind: "partial", Network: "1.0.0.0-1.0.2.255", Record: 0, OtherNetwork: "1.0.0.0/23", OtherRecord: 1}},
+		},
+		{
+			name:    "start_ip and end_ip range repeated as a cidr",
+			records: []JSONRecord{{StartIP: "2001:db8::", EndIP: "2001:db8::ffff", Data: a}, {Network: "2001:db8::/112", Data: a}},
+			want:    []Overlap{{Kind: "duplicate", Network: "2001:db8::/112", Record: 0, OtherNetwork: "2001:db8::/112", OtherRecord: 1, SameData: true}},
+		},
+		{
+			name:    "start_ip and end_ip range shadowing an earlier range",
+			records: []JSONRecord{{Network: "1.0.0.5-1.0.0.9", Data: a}, {StartIP: "1.0.0.1", EndIP: "1.0.0.20", Data: a}},
+			want:    []Overlap{{Kind: "shadowed", Network: "1.0.0.5-1.0.0.9", Record: 0, OtherNetwork: "1.0.0.1-1.0.0.20", OtherRecord: 1, SameData: true}},
+		},
+		{
+			name:    "crossing ranges",
+			records: []JSONRecord{{Network: "1.0.0.0-1.0.0.200", Data: a}, {Network: "1.0.0.100-1.0.1.50", Data: b}},
+			want:    []Overlap{{Kind: "partial", Network: "1.0.0.0-1.0.0.200", Record: 0, OtherNetwork: "1.0.0.100-1.0.1.50", OtherRecord: 1}},
+		},
+		{
+			name:    "crossing ranges with the same data",
+			records: []JSONRecord{{Network: "1.0.0.0-1.0.0.200", Data: a}, {Network: "1.0.0.100-1.0.1.50", Data: a}},
+			want:    nil,
+		},
+		{
+			name: "each pair reported once",
+			records: []JSONRecord{
+				{Network: "1.0.0.0-1.0.3.255", Data: a},
+				{Network: "1.0.1.0-1.0.2.127", Data: b},
+				{Network: "1.0.0.0/22", Data: a},
+			},
+			want: []Overlap{
+				{Kind: "partial", Network: "1.0.0.0/22", Record: 0, OtherNetwork: "1.0.1.0-1.0.2.127", OtherRecord: 1},
+				{Kind: "duplicate", Network: "1.0.0.0/22", Record: 0, OtherNetwork: "1.0.0.0/22", OtherRecord: 2, SameData: true},
+				{Kind: "shadowed", Network: "1.0.1.0-1.0.2.127", Record: 1, OtherNetwork: "1.0.0.0/22", OtherRecord: 2},
+			},
+		},
+		{
+			name:    "ipv4 and ipv6 ranges",
+			records: []JSONRecord{{Network: "1.0.0.0-1.0.0.255", Data: a}, {Network: "::-::ffff", Data: b}},
+			want:    nil,
+		},
+		{
+			name:    "invalid network is skipped",
+			records: []JSONRecord{{Network: "invalid", Data: a}, {Network: "1.0.0.0/24", Data: b}},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := &OverlapIndex{}
+			for i, record := range tt.records {
+				index.Add(record, i)
+			}
+			report := index.Report()
+			if len(report.Overlaps) != len(tt.want) {
+				t.Fatalf("overlaps = %+v, want %+v", report.Overlaps, tt.want)
+			}
+			for i, want := range tt.want {
+				if report.Overlaps[i] != want {
+					t.Errorf("overlap %d = %+v, want %+v", i, report.Overlaps[i], want)
+				}
+			}
+			if report.Total() != len(tt.want) {
+				t.Errorf("total = %d, want %d", report.Total(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestScanInputFileOverlapRecordIndex(t *testing.T) {
+	path := writeTestFile(t, "input.jsonl", `{"network":"1.0.0.0/24","data":{"a":1}}`+"\n"+
+		`not json`+"\n"+
+		`{"network":"1.0.0.0/24","data":{"a":2}}`+"\n")
+	index := &OverlapIndex{}
+	if _, _, err := scanInputFile([]string{path}, InputOptions{Format: "jsonl"}, index); err != nil {
+		t.Fatal(err)
+	}
+	report := index.Report()
+	if len(report.Overlaps) != 1 {
+		t.Fatalf("overlaps = %+v, want one", report.Overlaps)
+	}
+	if got := report.Overlaps[0]; got.Record != 0 || got.OtherRecord != 2 {
+		t.Errorf("records = %d and %d, want 0 and 2", got.Record, got.OtherRecord)
+	}
+
+	if err := locateOverlaps([]string{path}, InputOptions{Format: "jsonl"}, &report); err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Overlaps[0]; got.Location != "line 1" || got.OtherLocation != "line 3" {
+		t.Errorf("locations = %q and %q, want line 1 and line 3", got.Location, got.OtherLocation)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Record operations. A record without op is an insert.
+const (
+	// opInsert inserts the data using the --merge strategy
+	opInsert = "insert"
+	// opMerge deep merges the data into the existing data of the network
+	opMerge = "merge"
+	// opRemove removes the network and its data, the record has no data
+	opRemove = "remove"
+)
+
+var recordOps = []string{opInsert, opMerge, opRemove}
+
+// validateRecordOp checks the op of a record and whether it has data as the
+// op requires. It returns the field and message of the problem found.
+func validateRecordOp(record JSONRecord) (string, string) {
+	switch record.Op {
+	case "", opInsert, opMerge:
+		return "", ""
+	case opRemove:
+		if record.Data != nil {
+			return "data", "data is not allowed for remove"
+		}
+		return "", ""
+	default:
+		return "op", fmt.Sprintf("unsupported op %q, expected one of: %s", record.Op, joinStrings(recordOps))
+	}
+}
+
+// recordInserter returns the inserter for a record according to its op
+func recordInserter(op string, data mmdbtype.DataType, merge inserter.FuncGenerator) inserter.Func {
+	switch op {
+	case opRemove:
+		return inserter.Remove
+	case opMerge:
+		return inserter.DeepMergeWith(data)
+	default:
+		return merge(data)
+	}
+}
+
+// readBaseMetadata reads the metadata of the database given with --base
+func readBaseMetadata(filepath string) (Metadata, int, error) {
+	reader, err := maxminddb.Open(filepath)
+	if err != nil {
+		return Metadata{}, 0, fmt.Errorf("opening base MMDB file: %w", err)
+	}
+	defer reader.Close()
+
+	metadata := Metadata{
+		DatabaseType: reader.Metadata.DatabaseType,
+		Description:  reader.Metadata.Description,
+		Languages:    reader.Metadata.Languages,
+	}
+	return metadata, int(reader.Metadata.IPVersion), nil
+}
+
+// patchMetadata returns the base metadata with the fields set in the patch
+// replacing their base values. The build timestamp is not taken from the
+// base, a patched database is a new build.
+func patchMetadata(base, patch Metadata) Metadata {
+	metadata := base
+	if patch.DatabaseType != "" {
+		metadata.DatabaseType = patch.DatabaseType
+	}
+	if patch.Description != nil {
+		metadata.Description = patch.Description
+	}
+	if patch.Languages != nil {
+		metadata.Languages = patch.Languages
+	}
+	if patch.IPVersion != nil {
+		metadata.IPVersion = patch.IPVersion
+	}
+	if patch.RecordSize != nil {
+		metadata.RecordSize = patch.RecordSize
+	}
+	metadata.BinaryFormatMajorVersion = patch.BinaryFormatMajorVersion
+	metadata.BinaryFormatMinorVersion = patch.BinaryFormatMinorVersion
+	metadata.BuildTimestamp = patch.BuildTimestamp
+	return metadata
+}
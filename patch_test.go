@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+func TestValidateRecordOp(t *testing.T) {
+	data := map[string]any{"a": "x"}
+
+	tests := []struct {
+		name      string
+		record    JSONRecord
+		wantField string
+	}{
+		{"insert without op", JSONRecord{Data: data}, ""},
+		{"insert", JSONRecord{Op: opInsert, Data: data}, ""},
+		{"merge", JSONRecord{Op: opMerge, Data: data}, ""},
+		{"remove", JSONRecord{Op: opRemove}, ""},
+		{"remove with data", JSONRecord{Op: opRemove, Data: data}, "data"},
+		{"unknown op", JSONRecord{Op: "delete"}, "op"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, message := validateRecordOp(tt.record)
+			if field != tt.wantField {
+				t.Errorf("field = %q (%s), want %q", field, message, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestPatchMetadata(t *testing.T) {
+	base := Metadata{
+		DatabaseType: "Base",
+		Description:  map[string]string{"en": "base"},
+		Languages:    []string{"en"},
+		IPVersion:    intPtr(6),
+	}
+
+	tests := []struct {
+		name  string
+		patch Metadata
+		want  Metadata
+	}{
+		{
+			name:  "empty patch keeps the base",
+			patch: Metadata{},
+			want:  base,
+		},
+		{
+			name: "patch fields replace the base",
+			patch: Metadata{
+				DatabaseType: "Patched",
+				Description:  map[string]string{"de": "Basis"},
+				Languages:    []string{"de"},
+				RecordSize:   intPtr(28),
+			},
+			want: Metadata{
+				DatabaseType: "Patched",
+				Description:  map[string]string{"de": "Basis"},
+				Languages:    []string{"de"},
+				IPVersion:    intPtr(6),
+				RecordSize:   intPtr(28),
+			},
+		},
+		{
+			name:  "build timestamp comes from the patch only",
+			patch: Metadata{BuildTimestamp: int64Ptr(5)},
+			want: Metadata{
+				DatabaseType:   "Base",
+				Description:    map[string]string{"en": "base"},
+				Languages:      []string{"en"},
+				IPVersion:      intPtr(6),
+				BuildTimestamp: int64Ptr(5),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := base
+			base.BuildTimestamp = int64Ptr(1)
+			got := patchMetadata(base, tt.patch)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDatabaseWithBaseOps(t *testing.T) {
+	base := writeTestDatabase(t, "base.mmdb", map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"a": mmdbtype.String("x"), "b": mmdbtype.Map{"c": mmdbtype.Uint32(1)}},
+		"2.0.0.0/24": {"a": mmdbtype.String("y")},
+		"3.0.0.0/24": {"a": mmdbtype.String("z")},
+	})
+	input := writeTestFile(t, "patch.jsonl", `{"metadata":{"description":{"en":"patched"},"build_epoch":1}}
+{"network":"2.0.0.0/24","op":"remove"}
+{"network":"1.0.0.0/24","op":"merge","data":{"b":{"d":2},"e":true}}
+{"network":"1.0.0.128/25","op":"remove"}
+{"network":"3.0.0.0/25","data":{"a":"w"}}
+{"network":"4.0.0.0/24","op":"merge","data":{"a":"new"}}
+`)
+
+	opts, baseIPVersion, err := loadInputOptions(InputSettings{Format: "jsonl", BaseFile: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "patched.mmdb")
+	_, err = buildDatabase(BuildOptions{
+		Inputs:        []string{input},
+		Input:         opts,
+		Merge:         "replace",
+		Numbers:       numberPolicy,
+		BaseFile:      base,
+		BaseIPVersion: baseIPVersion,
+		Output:        output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := maxminddb.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if reader.Metadata.DatabaseType != "Test" || reader.Metadata.Description["en"] != "patched" {
+		t.Errorf("metadata = %+v", reader.Metadata)
+	}
+
+	for ip, want := range map[string]string{
+		"1.0.0.1":   `{"a":"x","b":{"c":1,"d":2},"e":true}`,
+		"1.0.0.200": "",
+		"2.0.0.1":   "",
+		"3.0.0.1":   `{"a":"w"}`,
+		"3.0.0.129": `{"a":"z"}`,
+		"4.0.0.1":   `{"a":"new"}`,
+	} {
+		var data any
+		if err := reader.Lookup(netip.MustParseAddr(ip)).Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if data != nil {
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = string(encoded)
+		}
+		if got != want {
+			t.Errorf("%s: got %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestBuildDatabaseRemoveWithData(t *testing.T) {
+	input := writeTestFile(t, "patch.json", `{"metadata":{"database_type":"Test","description":{"en":"test"},"build_epoch":1},"records":[{"network":"1.0.0.0/24","op":"remove","data":{"a":1}}]}`)
+	_, err := buildDatabase(BuildOptions{
+		Inputs:  []string{input},
+		Input:   InputOptions{Format: "json"},
+		Merge:   "replace",
+		Numbers: numberPolicy,
+		Output:  filepath.Join(t.TempDir(), "test.mmdb"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "validation failed") {
+		t.Errorf("error = %v, want the validation error", err)
+	}
+}
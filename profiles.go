@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// profiles are built-in mappings for common vendor range CSV files, used
+// with --profile instead of a --mapping file. The files have no header, so
+// columns are 1-based positions.
+var profiles = map[string]func() *CSVMapping{
+	// IP2Location LITE: "ip_from","ip_to","country_code","country_name",
+	// "region_name","city_name","latitude","longitude","zip_code","time_zone",
+	// each edition adds columns to the previous one.
+	"ip2location-db1":  func() *CSVMapping { return ip2locationProfile("DB1", 4) },
+	"ip2location-db3":  func() *CSVMapping { return ip2locationProfile("DB3", 6) },
+	"ip2location-db5":  func() *CSVMapping { return ip2locationProfile("DB5", 8) },
+	"ip2location-db9":  func() *CSVMapping { return ip2locationProfile("DB9", 9) },
+	"ip2location-db11": func() *CSVMapping { return ip2locationProfile("DB11", 10) },
+	// DB-IP lite: ip_start,ip_end,country
+	"dbip-country": func() *CSVMapping {
+		return dbipProfile("Country", []*CSVColumn{
+			{Column: "3", Field: "country.iso_code", Empty: "skip"},
+		})
+	},
+	// DB-IP lite: ip_start,ip_end,continent,country,stateprov,city,latitude,longitude
+	"dbip-city": func() *CSVMapping {
+		return dbipProfile("City", []*CSVColumn{
+			{Column: "3", Field: "continent.code"},
+			{Column: "4", Field: "country.iso_code", Empty: "skip"},
+			{Column: "5", Field: "region.names.en"},
+			{Column: "6", Field: "city.names.en"},
+			{Column: "7", Field: "location.latitude", Type: "double"},
+			{Column: "8", Field: "location.longitude", Type: "double"},
+		})
+	},
+	// DB-IP lite: ip_start,ip_end,asn,as_organization
+	"dbip-asn": func() *CSVMapping {
+		return dbipProfile("ASN", []*CSVColumn{
+			{Column: "3", Field: "autonomous_system_number", Type: "uint32", Empty: "skip"},
+			{Column: "4", Field: "autonomous_system_organization"},
+		})
+	},
+}
+
+// ip2locationColumns are the data columns of IP2Location LITE files, an
+// edition has the first n of them
+var ip2locationColumns = []*CSVColumn{
+	{Column: "3", Field: "country.iso_code", Empty: "skip"},
+	{Column: "4", Field: "country.names.en"},
+	{Column: "5", Field: "region.names.en"},
+	{Column: "6", Field: "city.names.en"},
+	{Column: "7", Field: "location.latitude", Type: "double"},
+	{Column: "8", Field: "location.longitude", Type: "double"},
+	{Column: "9", Field: "postal.code"},
+	{Column: "10", Field: "location.utc_offset"},
+}
+
+func ip2locationProfile(edition string, columns int) *CSVMapping {
+	mapping := &CSVMapping{
+		StartIP:    "1",
+		EndIP:      "2",
+		NoHeader:   true,
+		IntegerIPs: true,
+		// Unknown values are "-", rows without a country are unallocated
+		NullValues: []string{"-"},
+		// IPv6 files repeat the IPv4 data in the 6to4 and Teredo networks,
+		// which are aliases of the IPv4 networks in an MMDB
+		SkipNetworks: []string{"2001::/32", "2002::/16"},
+		Metadata: &Metadata{
+			DatabaseType: "IP2LOCATION-LITE-" + edition,
+			Description:  map[string]string{"en": "IP2Location LITE " + edition + " imported from CSV"},
+			Languages:    []string{"en"},
+		},
+	}
+	for _, column := range ip2locationColumns[:columns-2] {
+		c := *column
+		mapping.Columns = append(mapping.Columns, &c)
+	}
+	return mapping
+}
+
+func dbipProfile(edition string, columns []*CSVColumn) *CSVMapping {
+	return &CSVMapping{
+		StartIP:  "1",
+		EndIP:    "2",
+		NoHeader: true,
+		Columns:  columns,
+		Metadata: &Metadata{
+			DatabaseType: "DBIP-" + edition + "-Lite",
+			Description:  map[string]string{"en": "DB-IP " + edition + " Lite imported from CSV"},
+			Languages:    []string{"en"},
+		},
+	}
+}
+
+// profileMapping returns the mapping of a built-in profile
+func profileMapping(name string) (*CSVMapping, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, expected one of: %s", name, joinStrings(profileNames()))
+	}
+	mapping := profile()
+	if err := mapping.prepare(); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return mapping, nil
+}
+
+// profileNames returns the names of the built-in profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
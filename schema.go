@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema declares the MMDB type and shape of every data field. It is used to
+// report records that do not match in check mode and to coerce values to the
+// declared types before they are inserted.
+//
+// Example (YAML):
+//
+//	fields:
+//	  city: string
+//	  country:
+//	    type: string
+//	    required: true
+//	    enum: [US, DE]
+//	  properties.accuracy: uint16
+type Schema struct {
+	Fields map[string]*SchemaField `json:"fields" yaml:"fields"`
+	// AllowUnknown accepts data fields the schema does not declare
+	AllowUnknown bool `json:"allow_unknown" yaml:"allow_unknown"`
+}
+
+// SchemaField describes one data field. In the schema file a field can also
+// be given as just its type name, e.g. `city: string`.
+type SchemaField struct {
+	// Type is an MMDB type name (see typedValueTypes), "map", "array" or
+	// "any" to accept any value.
+	Type     string                  `json:"type" yaml:"type"`
+	Required bool                    `json:"required" yaml:"required"`
+	Enum     []any                   `json:"enum" yaml:"enum"`
+	Fields   map[string]*SchemaField `json:"fields" yaml:"fields"`
+	Items    *SchemaField            `json:"items" yaml:"items"`
+}
+
+// schemaFieldAlias avoids recursing into the custom unmarshalers
+type schemaFieldAlias SchemaField
+
+// schemaFieldKeys are the keys of a field definition. The custom unmarshalers
+// do not inherit the strict decoding of decodeConfigFile, so they check the
+// keys themselves.
+var schemaFieldKeys = []string{"type", "required", "enum", "fields", "items"}
+
+func (f *SchemaField) UnmarshalJSON(data []byte) error {
+	var typeName string
+	if err := json.Unmarshal(data, &typeName); err == nil {
+		f.Type = typeName
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	return dec.Decode((*schemaFieldAlias)(f))
+}
+
+func (f *SchemaField) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Type = node.Value
+		return nil
+	}
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if !containsString(schemaFieldKeys, key.Value) {
+				return fmt.Errorf("line %d: unknown schema field key %q, expected one of: %s", key.Line, key.Value, joinStrings(schemaFieldKeys))
+			}
+		}
+	}
+	return node.Decode((*schemaFieldAlias)(f))
+}
+
+func loadSchema(path string) (*Schema, error) {
+	schema := &Schema{}
+	if err := decodeConfigFile(path, schema); err != nil {
+		return nil, err
+	}
+
+	fields, err := expandSchemaFields(schema.Fields, "")
+	if err != nil {
+		return nil, err
+	}
+	schema.Fields = fields
+
+	if len(schema.Fields) == 0 {
+		return nil, fmt.Errorf("schema declares no fields")
+	}
+	if err := checkSchemaFields(schema.Fields, ""); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// expandSchemaFields turns dotted field names like "location.latitude" into
+// nested map fields.
+func expandSchemaFields(fields map[string]*SchemaField, path string) (map[string]*SchemaField, error) {
+	expanded := make(map[string]*SchemaField)
+	for _, name := range sortedKeys(fields) {
+		field := fields[name]
+		if field == nil {
+			return nil, fmt.Errorf("schema field %s%s has no definition", path, name)
+		}
+		var err error
+		if field.Fields, err = expandSchemaFields(field.Fields, path+name+"."); err != nil {
+			return nil, err
+		}
+
+		parts := strings.Split(name, ".")
+		parent := expanded
+		for i, part := range parts[:len(parts)-1] {
+			existing, ok := parent[part]
+			if !ok {
+				existing = &SchemaField{Type: "map", Fields: make(map[string]*SchemaField)}
+				parent[part] = existing
+			}
+			if existing.Type != "map" {
+				return nil, fmt.Errorf("schema field %s%s is declared as %s and cannot hold nested fields", path, strings.Join(parts[:i+1], "."), existing.Type)
+			}
+			if existing.Fields == nil {
+				existing.Fields = make(map[string]*SchemaField)
+			}
+			parent = existing.Fields
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := parent[last]; ok {
+			return nil, fmt.Errorf("schema field %s%s is declared more than once", path, name)
+		}
+		parent[last] = field
+	}
+	return expanded, nil
+}
+
+func checkSchemaFields(fields map[string]*SchemaField, path string) error {
+	for name, field := range fields {
+		fieldPath := path + name
+		if name == "" {
+			return fmt.Errorf("schema field name cannot be empty")
+		}
+		if err := checkSchemaField(field, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSchemaField(field *SchemaField, path string) error {
+	switch field.Type {
+	case "map":
+		if field.Items != nil {
+			return fmt.Errorf("schema field %s: items is only valid for arrays", path)
+		}
+		return checkSchemaFields(field.Fields, path+".")
+	case "array":
+		if len(field.Fields) > 0 {
+			return fmt.Errorf("schema field %s: fields is only valid for maps", path)
+		}
+		if field.Items != nil {
+			return checkSchemaField(field.Items, path+"[]")
+		}
+	case "any":
+	default:
+		if _, ok := typedValueKeys[field.Type]; !ok {
+			return fmt.Errorf("schema field %s: unsupported type %q, expected map, array, any or one of: %s", path, field.Type, joinStrings(typedValueTypes()))
+		}
+		if len(field.Fields) > 0 || field.Items != nil {
+			return fmt.Errorf("schema field %s: %s cannot have fields or items", path, field.Type)
+		}
+	}
+	return nil
+}
+
+// Validate adds an error to ve for every part of data that breaks the schema
+func (s *Schema) Validate(data map[string]any, path string, ve *ValidationErrors) {
+	s.applyFields(data, s.Fields, path, ve)
+}
+
+// Coerce returns a copy of data with every declared value converted to its
+// declared MMDB type, or the first schema violation.
+func (s *Schema) Coerce(data map[string]any) (map[string]any, error) {
+	ve := &ValidationErrors{}
+	coerced := s.applyFields(data, s.Fields, "data", ve)
+	if ve.HasErrors() {
+		return nil, &ve.Errors[0]
+	}
+	return coerced, nil
+}
+
+func (s *Schema) applyFields(data map[string]any, fields map[string]*SchemaField, path string, ve *ValidationErrors) map[string]any {
+	result := make(map[string]any, len(data))
+
+	for _, name := range sortedKeys(fields) {
+		if _, ok := data[name]; !ok && fields[name].Required {
+			ve.Add(fmt.Sprintf("%s.%s", path, name), "required field is missing")
+		}
+	}
+
+	for _, key := range sortedKeys(data) {
+		value := data[key]
+		fieldPath := fmt.Sprintf("%s.%s", path, key)
+
+		field, ok := fields[key]
+		if !ok {
+			if !s.AllowUnknown {
+				ve.Add(fieldPath, "field is not declared in schema")
+			}
+			result[key] = value
+			continue
+		}
+		result[key] = s.apply(value, field, fieldPath, ve)
+	}
+
+	return result
+}
+
+func (s *Schema) apply(value any, field *SchemaField, path string, ve *ValidationErrors) any {
+	if field.Type == "any" {
+		return value
+	}
+
+	// Explicitly typed values must agree with the schema
+	if m, ok := value.(map[string]any); ok && isTypedValue(m) {
+		if annotated, _ := m[typeKey].(string); annotated != field.Type {
+			ve.Add(path, fmt.Sprintf("declared as %s but annotated as %v", field.Type, m[typeKey]))
+			return value
+		}
+		if !enumContains(field.Enum, m["value"]) {
+			ve.Add(path, fmt.Sprintf("value %s is not one of: %s", enumString(m["value"]), enumList(field.Enum)))
+		}
+		return value
+	}
+
+	switch field.Type {
+	case "map":
+		m, ok := value.(map[string]any)
+		if !ok {
+			ve.Add(path, fmt.Sprintf("expected map, got %s", jsonTypeName(value)))
+			return value
+		}
+		return s.applyFields(m, field.Fields, path, ve)
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			ve.Add(path, fmt.Sprintf("expected array, got %s", jsonTypeName(value)))
+			return value
+		}
+		if field.Items == nil {
+			return items
+		}
+		result := make([]any, len(items))
+		for i, item := range items {
+			result[i] = s.apply(item, field.Items, fmt.Sprintf("%s[%d]", path, i), ve)
+		}
+		return result
+	default:
+		coerced, err := coerceScalar(value, field.Type)
+		if err != nil {
+			ve.Add(path, err.Error())
+			return value
+		}
+		if !enumContains(field.Enum, value) {
+			ve.Add(path, fmt.Sprintf("value %s is not one of: %s", enumString(value), enumList(field.Enum)))
+		}
+		return coerced
+	}
+}
+
+// coerceScalar converts a JSON scalar to a value that convertToMMDBType
+// writes as mmdbType, accepting numbers and booleans given as strings.
+func coerceScalar(value any, mmdbType string) (any, error) {
+	switch mmdbType {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("expected bool, got %q", v)
+			}
+			return b, nil
+		}
+	case "bytes":
+		if s, ok := value.(string); ok {
+			typed := map[string]any{typeKey: mmdbType, "base64": s}
+			if _, err := convertTypedValue(typed); err != nil {
+				return nil, err
+			}
+			return typed, nil
+		}
+	default:
+		switch value.(type) {
+		case json.Number, string:
+			typed := map[string]any{typeKey: mmdbType, "value": value}
+			if _, err := convertTypedValue(typed); err != nil {
+				return nil, err
+			}
+			return typed, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %s", mmdbType, jsonTypeName(value))
+}
+
+func enumContains(enum []any, value any) bool {
+	if len(enum) == 0 {
+		return true
+	}
+	s := enumString(value)
+	for _, item := range enum {
+		if enumString(item) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(value any) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(value)
+}
+
+func enumList(enum []any) string {
+	items := make([]string, len(enum))
+	for i, item := range enum {
+		items[i] = enumString(item)
+	}
+	return joinStrings(items)
+}
+
+// jsonTypeName names the JSON type of a decoded value for error messages
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		if isTypedValue(v) {
+			return fmt.Sprintf("%v", v[typeKey])
+		}
+		return "map"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// sortedKeys returns the keys of a string keyed map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
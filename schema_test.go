@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{name: "json shorthand", file: "schema.json", content: `{"fields":{"city":"string","asn":{"type":"uint32","required":true}}}`},
+		{name: "yaml shorthand", file: "schema.yaml", content: "fields:\n  city: string\n  asn:\n    type: uint32\n    required: true\n"},
+		{name: "json unknown field key", file: "schema.json", content: `{"fields":{"asn":{"type":"uint32","requird":true}}}`, wantErr: "requird"},
+		{name: "yaml unknown field key", file: "schema.yaml", content: "fields:\n  asn:\n    type: uint32\n    requird: true\n", wantErr: `unknown schema field key "requird"`},
+		{name: "yaml unknown nested field key", file: "schema.yaml", content: "fields:\n  tags:\n    type: array\n    items:\n      type: string\n      enums: [a]\n", wantErr: `unknown schema field key "enums"`},
+		{name: "json unknown top-level key", file: "schema.json", content: `{"fields":{"a":"string"},"allow_unkown":true}`, wantErr: "allow_unkown"},
+		{name: "yaml unknown top-level key", file: "schema.yaml", content: "fields:\n  a: string\nallow_unkown: true\n", wantErr: "allow_unkown"},
+		{name: "no fields", file: "schema.json", content: `{"fields":{}}`, wantErr: "schema declares no fields"},
+		{name: "unsupported type", file: "schema.json", content: `{"fields":{"a":"text"}}`, wantErr: `unsupported type "text"`},
+		{name: "items on a map", file: "schema.json", content: `{"fields":{"a":{"type":"map","items":"string"}}}`, wantErr: "items is only valid for arrays"},
+		{name: "fields on a scalar", file: "schema.json", content: `{"fields":{"a":{"type":"string","fields":{"b":"string"}}}}`, wantErr: "string cannot have fields or items"},
+		{name: "field without a definition", file: "schema.json", content: `{"fields":{"a":null}}`, wantErr: "schema field a has no definition"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSchema(writeTestFile(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestExpandSchemaFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]*SchemaField
+		want    map[string]*SchemaField
+		wantErr string
+	}{
+		{
+			name:   "dotted names become nested maps",
+			fields: map[string]*SchemaField{"location.latitude": {Type: "double"}, "location.longitude": {Type: "double"}},
+			want: map[string]*SchemaField{
+				"location": {Type: "map", Fields: map[string]*SchemaField{
+					"latitude":  {Type: "double", Fields: map[string]*SchemaField{}},
+					"longitude": {Type: "double", Fields: map[string]*SchemaField{}},
+				}},
+			},
+		},
+		{
+			name: "dotted name inside a declared map",
+			fields: map[string]*SchemaField{
+				"location":          {Type: "map", Required: true},
+				"location.accuracy": {Type: "uint16"},
+			},
+			want: map[string]*SchemaField{
+				"location": {Type: "map", Required: true, Fields: map[string]*SchemaField{
+					"accuracy": {Type: "uint16", Fields: map[string]*SchemaField{}},
+				}},
+			},
+		},
+		{
+			name:   "dotted names in nested fields",
+			fields: map[string]*SchemaField{"a": {Type: "map", Fields: map[string]*SchemaField{"b.c": {Type: "string"}}}},
+			want: map[string]*SchemaField{
+				"a": {Type: "map", Fields: map[string]*SchemaField{
+					"b": {Type: "map", Fields: map[string]*SchemaField{
+						"c": {Type: "string", Fields: map[string]*SchemaField{}},
+					}},
+				}},
+			},
+		},
+		{
+			name:    "nested field below a scalar",
+			fields:  map[string]*SchemaField{"a": {Type: "string"}, "a.b": {Type: "string"}},
+			wantErr: "schema field a is declared as string and cannot hold nested fields",
+		},
+		{
+			name: "field declared twice",
+			fields: map[string]*SchemaField{
+				"a":   {Type: "map", Fields: map[string]*SchemaField{"b": {Type: "string"}}},
+				"a.b": {Type: "string"},
+			},
+			wantErr: "schema field a.b is declared more than once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandSchemaFields(tt.fields, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestSchemaCoerce(t *testing.T) {
+	schema, err := loadSchema(writeTestFile(t, "schema.yaml", `
+fields:
+  city: string
+  asn:
+    type: uint32
+    required: true
+  port:
+    type: uint16
+    enum: [80, 443]
+  country:
+    type: string
+    enum: [US, DE]
+  active: bool
+  raw: bytes
+  tags:
+    type: array
+    items: uint16
+  location.latitude: double
+  extra: any
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "values coerced to their declared types",
+			data: `{"asn":"64512","city":7,"active":"true","port":443,"raw":"3q0=","tags":[1,"2"],"location":{"latitude":"1.5"},"extra":{"x":[1]}}`,
+			want: `{"active":true,"asn":{"$type":"uint32","value":"64512"},"city":"7",` +
+				`"extra":{"x":[1]},"location":{"latitude":{"$type":"double","value":"1.5"}},` +
+				`"port":{"$type":"uint16","value":443},"raw":{"$type":"bytes","base64":"3q0="},` +
+				`"tags":[{"$type":"uint16","value":1},{"$type":"uint16","value":"2"}]}`,
+		},
+		{
+			name: "annotated value of the declared type",
+			data: `{"asn":{"$type":"uint32","value":1}}`,
+			want: `{"asn":{"$type":"uint32","value":1}}`,
+		},
+		{name: "missing required field", data: `{"city":"x"}`, wantErr: "data.asn: required field is missing"},
+		{name: "undeclared field", data: `{"asn":1,"name":"x"}`, wantErr: "data.name: field is not declared in schema"},
+		{name: "value out of range", data: `{"asn":4294967296}`, wantErr: "data.asn"},
+		{name: "wrong type", data: `{"asn":1,"active":1}`, wantErr: "data.active: expected bool, got number"},
+		{name: "map expected", data: `{"asn":1,"location":"x"}`, wantErr: "data.location: expected map, got string"},
+		{name: "array expected", data: `{"asn":1,"tags":1}`, wantErr: "data.tags: expected array, got number"},
+		{name: "array item", data: `{"asn":1,"tags":[1,-1]}`, wantErr: "data.tags[1]"},
+		{name: "string not in enum", data: `{"asn":1,"country":"FR"}`, wantErr: `data.country: value "FR" is not one of: "US", "DE"`},
+		{name: "number not in enum", data: `{"asn":1,"port":8080}`, wantErr: "data.port: value 8080 is not one of: 80, 443"},
+		{name: "number string matching the enum", data: `{"asn":1,"port":"80"}`, wantErr: `data.port: value "80" is not one of: 80, 443`},
+		{name: "annotated value not in enum", data: `{"asn":1,"port":{"$type":"uint16","value":22}}`, wantErr: "data.port: value 22 is not one of: 80, 443"},
+		{name: "annotated with another type", data: `{"asn":{"$type":"uint64","value":1}}`, wantErr: "data.asn: declared as uint32 but annotated as uint64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]any
+			if err := unmarshalJSON([]byte(tt.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			got, err := schema.Coerce(data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want map[string]any
+			if err := unmarshalJSON([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("got %s, want %s", gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := loadSchema(writeTestFile(t, "schema.json", `{"fields":{"a":{"type":"string","required":true},"b":"uint16","c":{"type":"map","fields":{"d":"bool"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		allowUnknown bool
+		data         string
+		want         []string
+	}{
+		{name: "valid", data: `{"a":"x","b":1,"c":{"d":true}}`},
+		{name: "every error collected", data: `{"b":-1,"c":{"d":"maybe"},"e":1}`, want: []string{"data.a", "data.b", "data.c.d", "data.e"}},
+		{name: "unknown fields allowed", allowUnknown: true, data: `{"a":"x","e":1,"c":{"f":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]any
+			if err := unmarshalJSON([]byte(tt.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			s := *schema
+			s.AllowUnknown = tt.allowUnknown
+			ve := &ValidationErrors{}
+			s.Validate(data, "data", ve)
+
+			var fields []string
+			for _, e := range ve.Errors {
+				fields = append(fields, e.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("errors = %v, want fields %q", ve.Errors, tt.want)
+			}
+		})
+	}
+}
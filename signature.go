@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// exitSignatureMismatch is the exit code of --verify when the signature of
+// the database is missing or does not match --pubkey
+const exitSignatureMismatch = 3
+
+// errSignatureMismatch is returned by verifySignatureFile when the signature
+// is missing or the database is not signed by the key
+var errSignatureMismatch = errors.New("signature check failed")
+
+// loadSigningKey reads an ed25519 private key in PKCS #8 PEM form, as
+// written by `openssl genpkey -algorithm ed25519`
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+	return privateKey, nil
+}
+
+// loadPublicKey reads an ed25519 public key in PKIX PEM form, as written by
+// `openssl pkey -pubout`
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+	return publicKey, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	return block, nil
+}
+
+// writeSignatureFile signs the bytes of a database and writes the raw 64
+// byte signature to path.sig, which `openssl pkeyutl -verify -rawin` reads
+func writeSignatureFile(path string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading database: %w", err)
+	}
+	signature := ed25519.Sign(key, data)
+	return writeFileAtomic(path+".sig", func(w io.Writer) error {
+		_, err := w.Write(signature)
+		return err
+	})
+}
+
+// verifySignatureFile checks the detached signature of a database
+func verifySignatureFile(path, signaturePath string, key ed25519.PublicKey) error {
+	signature, err := os.ReadFile(signaturePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s not found", errSignatureMismatch, signaturePath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading signature: %w", err)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: %s is not an ed25519 signature", errSignatureMismatch, signaturePath)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading database: %w", err)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("%w: %s does not match the database", errSignatureMismatch, signaturePath)
+	}
+	return nil
+}
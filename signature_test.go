@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignatureFile(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		key          ed25519.PublicKey
+		prepare      func(t *testing.T, path string)
+		wantMismatch bool
+	}{
+		{name: "valid signature", key: publicKey},
+		{name: "other key", key: otherKey, wantMismatch: true},
+		{
+			name: "changed database",
+			key:  publicKey,
+			prepare: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMismatch: true,
+		},
+		{
+			name: "missing signature",
+			key:  publicKey,
+			prepare: func(t *testing.T, path string) {
+				if err := os.Remove(path + ".sig"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMismatch: true,
+		},
+		{
+			name: "truncated signature",
+			key:  publicKey,
+			prepare: func(t *testing.T, path string) {
+				if err := os.WriteFile(path+".sig", []byte("short"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMismatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "test.mmdb", "database")
+			if err := writeSignatureFile(path, privateKey); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, path)
+			}
+
+			err := verifySignatureFile(path, path+".sig", tt.key)
+			if tt.wantMismatch {
+				if !errors.Is(err, errSignatureMismatch) {
+					t.Errorf("error = %v, want a signature mismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestBuildDatabaseSignKeyNeedsRegularOutput(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := writeTestInput(t)
+
+	_, err = buildDatabase(BuildOptions{
+		Inputs:  []string{input},
+		Input:   InputOptions{Format: "json"},
+		Merge:   "replace",
+		Numbers: numberPolicy,
+		Output:  os.DevNull,
+		SignKey: privateKey,
+	})
+	if err == nil || !strings.Contains(err.Error(), "regular output file") {
+		t.Errorf("error = %v, want the regular file error", err)
+	}
+
+	output := filepath.Join(t.TempDir(), "test.mmdb")
+	_, err = buildDatabase(BuildOptions{
+		Inputs:  []string{input},
+		Input:   InputOptions{Format: "json"},
+		Merge:   "replace",
+		Numbers: numberPolicy,
+		Output:  output,
+		SignKey: privateKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySignatureFile(output, output+".sig", privateKey.Public().(ed25519.PublicKey)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// typeKey marks a JSON object as an explicitly typed value, for example
+// {"$type": "uint128", "value": "340282366920938463463374607431768211455"}
+// or {"$type": "bytes", "base64": "3q2+7w=="}.
+const typeKey = "$type"
+
+// typedValueKeys lists the keys a typed value may carry besides typeKey
+var typedValueKeys = map[string][]string{
+	"string":  {"value"},
+	"bool":    {"value"},
+	"double":  {"value"},
+	"float":   {"value"},
+	"uint16":  {"value"},
+	"uint32":  {"value"},
+	"uint64":  {"value"},
+	"uint128": {"value"},
+	"int32":   {"value"},
+	"bytes":   {"base64", "hex"},
+}
+
+// isTypedValue reports whether a JSON object is a typed value wrapper
+func isTypedValue(m map[string]interface{}) bool {
+	_, ok := m[typeKey]
+	return ok
+}
+
+// convertTypedValue converts a typed value wrapper into the MMDB type it
+// names.
+func convertTypedValue(m map[string]interface{}) (mmdbtype.DataType, error) {
+	mmdbType, ok := m[typeKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a string", typeKey)
+	}
+
+	allowed, ok := typedValueKeys[mmdbType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported %s %q, expected one of: %s", typeKey, mmdbType, joinStrings(typedValueTypes()))
+	}
+
+	// Exactly one value key must be present, and nothing else
+	var valueKey string
+	for key := range m {
+		if key == typeKey {
+			continue
+		}
+		if !containsString(allowed, key) {
+			return nil, fmt.Errorf("unexpected key %q for %s value, expected one of: %s", key, mmdbType, joinStrings(allowed))
+		}
+		if valueKey != "" {
+			return nil, fmt.Errorf("only one of %s can be set for %s value", joinStrings(allowed), mmdbType)
+		}
+		valueKey = key
+	}
+	if valueKey == "" {
+		return nil, fmt.Errorf("%s value requires one of: %s", mmdbType, joinStrings(allowed))
+	}
+	value := m[valueKey]
+
+	switch mmdbType {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("string value must be a JSON string")
+		}
+		return mmdbtype.String(s), nil
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("bool value must be true or false")
+		}
+		return mmdbtype.Bool(b), nil
+	case "bytes":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("bytes %s must be a JSON string", valueKey)
+		}
+		var data []byte
+		var err error
+		if valueKey == "base64" {
+			data, err = base64.StdEncoding.DecodeString(s)
+		} else {
+			data, err = hex.DecodeString(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s bytes: %w", valueKey, err)
+		}
+		return mmdbtype.Bytes(data), nil
+	case "double", "float":
+		s, err := numberString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s value %w", mmdbType, err)
+		}
+		return convertFloat(s, mmdbType)
+	default:
+		s, err := numberString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s value %w", mmdbType, err)
+		}
+		if !isIntegerLiteral(s) {
+			return nil, fmt.Errorf("%s value must be an integer: %s", mmdbType, s)
+		}
+		return convertInteger(s, mmdbType)
+	}
+}
+
+// numberString returns the textual form of a number given either as a JSON
+// number or as a string, the latter being needed for values beyond 2^53.
+func numberString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case string:
+		s := strings.TrimSpace(v)
+		if _, err := json.Number(s).Float64(); err != nil {
+			return "", fmt.Errorf("is not a number: %q", v)
+		}
+		return s, nil
+	default:
+		return "", fmt.Errorf("must be a number or a numeric string")
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// typedValueTypes returns the supported $type names, sorted
+func typedValueTypes() []string {
+	types := make([]string, 0, len(typedValueKeys))
+	for t := range typedValueKeys {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestConvertTypedValue(t *testing.T) {
+	uint128Max, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+
+	tests := []struct {
+		input   string
+		want    mmdbtype.DataType
+		wantErr bool
+	}{
+		{`{"$type":"uint16","value":80}`, mmdbtype.Uint16(80), false},
+		{`{"$type":"uint16","value":"80"}`, mmdbtype.Uint16(80), false},
+		{`{"$type":"uint16","value":70000}`, nil, true},
+		{`{"$type":"uint32","value":1.5}`, nil, true},
+		{`{"$type":"uint64","value":"18446744073709551615"}`, mmdbtype.Uint64(18446744073709551615), false},
+		{`{"$type":"uint128","value":"340282366920938463463374607431768211455"}`, (*mmdbtype.Uint128)(uint128Max), false},
+		{`{"$type":"int32","value":-5}`, mmdbtype.Int32(-5), false},
+		{`{"$type":"double","value":1}`, mmdbtype.Float64(1), false},
+		{`{"$type":"float","value":"2.5"}`, mmdbtype.Float32(2.5), false},
+		{`{"$type":"string","value":"x"}`, mmdbtype.String("x"), false},
+		{`{"$type":"string","value":1}`, nil, true},
+		{`{"$type":"bool","value":true}`, mmdbtype.Bool(true), false},
+		{`{"$type":"bool","value":"true"}`, nil, true},
+		{`{"$type":"bytes","base64":"3q2+7w=="}`, mmdbtype.Bytes{0xde, 0xad, 0xbe, 0xef}, false},
+		{`{"$type":"bytes","hex":"deadbeef"}`, mmdbtype.Bytes{0xde, 0xad, 0xbe, 0xef}, false},
+		{`{"$type":"bytes","hex":"xyz"}`, nil, true},
+		{`{"$type":"bytes","hex":"de","base64":"3g=="}`, nil, true},
+		{`{"$type":"bytes","value":"de"}`, nil, true},
+		{`{"$type":"uint16"}`, nil, true},
+		{`{"$type":"int64","value":1}`, nil, true},
+		{`{"$type":1,"value":1}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var m map[string]interface{}
+			if err := unmarshalJSON([]byte(tt.input), &m); err != nil {
+				t.Fatal(err)
+			}
+			if !isTypedValue(m) {
+				t.Fatal("not recognised as a typed value")
+			}
+			got, err := convertTypedValue(m)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertToMMDBTypeNestedTypedValues(t *testing.T) {
+	var data map[string]interface{}
+	input := `{"port":{"$type":"uint16","value":443},"tags":[{"$type":"string","value":"a"},"b"],"plain":{"type":"x"}}`
+	if err := unmarshalJSON([]byte(input), &data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := convertToMMDBType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mmdbtype.Map{
+		"port":  mmdbtype.Uint16(443),
+		"tags":  mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+		"plain": mmdbtype.Map{"type": mmdbtype.String("x")},
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}